@@ -0,0 +1,10 @@
+// Package contractinfo holds the description every auction contract returns from
+// GetContractInfo, so clients can adapt to whichever variant is installed on a channel.
+package contractinfo
+
+type ContractInfo struct {
+	AuctionType     string `json:"auctionType"`
+	Version         string `json:"version"`
+	SupportsReserve bool   `json:"supportsReserve"`
+	SupportsSealed  bool   `json:"supportsSealed"`
+}
@@ -0,0 +1,3 @@
+module github.com/khalidzahra/contractinfo
+
+go 1.22.4
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/khalidzahra/contractinfo"
+)
+
+type Order struct {
+	OrderID   string  `json:"orderID"`
+	Trader    string  `json:"trader"`
+	Volume    float64 `json:"volume"`
+	Price     int64   `json:"priceMinor"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+type Trade struct {
+	AskID  string  `json:"askID"`
+	BidID  string  `json:"bidID"`
+	Seller string  `json:"seller"`
+	Buyer  string  `json:"buyer"`
+	Volume float64 `json:"volume"`
+	Price  int64   `json:"priceMinor"`
+}
+
+type MarketClearing struct {
+	ClearingID    string  `json:"clearingID"`
+	ClearingPrice int64   `json:"clearingPriceMinor"`
+	Trades        []Trade `json:"trades"`
+	UnmatchedAsks []Order `json:"unmatchedAsks"`
+	UnmatchedBids []Order `json:"unmatchedBids"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+const (
+	askObjectType      = "ask"
+	bidObjectType      = "bid"
+	clearingObjectType = "clearing"
+)
+
+const (
+	roleAttribute = "role"
+	producerRole  = "producer"
+	consumerRole  = "consumer"
+)
+
+// Ask and bid prices are kept in cents so that matching compares integers.
+const minorUnitsPerUnit = 100
+
+const contractVersion = "1.0.0"
+
+type DoubleAuctionContract struct {
+	contractapi.Contract
+}
+
+// GetContractInfo reports the double auction, which clears asks against bids at one uniform
+// price and has neither reserves nor sealed bids.
+func (ac *DoubleAuctionContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*contractinfo.ContractInfo, error) {
+	return &contractinfo.ContractInfo{
+		AuctionType:     "double",
+		Version:         contractVersion,
+		SupportsReserve: false,
+		SupportsSealed:  false,
+	}, nil
+}
+
+func (ac *DoubleAuctionContract) SubmitAsk(ctx contractapi.TransactionContextInterface, orderID string, volume, minPrice float64) error {
+	if err := ac.checkRole(ctx, producerRole); err != nil {
+		return err
+	}
+	return ac.submitOrder(ctx, askObjectType, orderID, volume, minPrice)
+}
+
+func (ac *DoubleAuctionContract) SubmitBid(ctx contractapi.TransactionContextInterface, orderID string, volume, maxPrice float64) error {
+	if err := ac.checkRole(ctx, consumerRole); err != nil {
+		return err
+	}
+	return ac.submitOrder(ctx, bidObjectType, orderID, volume, maxPrice)
+}
+
+// ClearMarket matches the cheapest asks against the highest bids until the next bid is below
+// the next ask. Every trade settles at a uniform price halfway between the last matched ask
+// and bid. Unfilled volume on either side stays in the book for the next clearing.
+func (ac *DoubleAuctionContract) ClearMarket(ctx contractapi.TransactionContextInterface) (*MarketClearing, error) {
+	asks, err := ac.fetchOrders(ctx, askObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	bids, err := ac.fetchOrders(ctx, bidObjectType)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(asks, func(i, j int) bool {
+		if asks[i].Price != asks[j].Price {
+			return asks[i].Price < asks[j].Price
+		}
+		if asks[i].Timestamp != asks[j].Timestamp {
+			return asks[i].Timestamp < asks[j].Timestamp
+		}
+		return asks[i].OrderID < asks[j].OrderID
+	})
+
+	sort.Slice(bids, func(i, j int) bool {
+		if bids[i].Price != bids[j].Price {
+			return bids[i].Price > bids[j].Price
+		}
+		if bids[i].Timestamp != bids[j].Timestamp {
+			return bids[i].Timestamp < bids[j].Timestamp
+		}
+		return bids[i].OrderID < bids[j].OrderID
+	})
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clearing := MarketClearing{
+		ClearingID:    ctx.GetStub().GetTxID(),
+		Trades:        []Trade{},
+		UnmatchedAsks: []Order{},
+		UnmatchedBids: []Order{},
+		Timestamp:     currentTime,
+	}
+
+	i, j := 0, 0
+	var marginalAsk, marginalBid int64
+	for i < len(asks) && j < len(bids) && bids[j].Price >= asks[i].Price {
+		volume := math.Min(asks[i].Volume, bids[j].Volume)
+		clearing.Trades = append(clearing.Trades, Trade{
+			AskID:  asks[i].OrderID,
+			BidID:  bids[j].OrderID,
+			Seller: asks[i].Trader,
+			Buyer:  bids[j].Trader,
+			Volume: volume,
+		})
+		marginalAsk, marginalBid = asks[i].Price, bids[j].Price
+
+		asks[i].Volume -= volume
+		bids[j].Volume -= volume
+		if asks[i].Volume <= 0 {
+			i++
+		}
+		if bids[j].Volume <= 0 {
+			j++
+		}
+	}
+
+	if len(clearing.Trades) > 0 {
+		clearing.ClearingPrice = (marginalAsk + marginalBid) / 2
+		for k := range clearing.Trades {
+			clearing.Trades[k].Price = clearing.ClearingPrice
+		}
+	}
+
+	updates := make(map[string][]byte)
+
+	if err := ac.updateBook(ctx, askObjectType, asks, i, updates, &clearing.UnmatchedAsks); err != nil {
+		return nil, err
+	}
+
+	if err := ac.updateBook(ctx, bidObjectType, bids, j, updates, &clearing.UnmatchedBids); err != nil {
+		return nil, err
+	}
+
+	clearingKey := ac.createCompositeKey(ctx, clearingObjectType, clearing.ClearingID)
+	clearingJSON, err := json.Marshal(clearing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal market clearing: %v", err)
+	}
+	updates[clearingKey] = clearingJSON
+
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return nil, err
+	}
+
+	return &clearing, nil
+}
+
+func (ac *DoubleAuctionContract) GetMarketClearing(ctx contractapi.TransactionContextInterface, clearingID string) (*MarketClearing, error) {
+	clearingKey := ac.createCompositeKey(ctx, clearingObjectType, clearingID)
+
+	fetchedClearing, err := ctx.GetStub().GetState(clearingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve market clearing: %v", err)
+	}
+	if fetchedClearing == nil {
+		return nil, fmt.Errorf("market clearing with ID %s does not exist", clearingID)
+	}
+
+	var clearing MarketClearing
+	if err := json.Unmarshal(fetchedClearing, &clearing); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal market clearing: %v", err)
+	}
+	return &clearing, nil
+}
+
+// Helper functions
+
+// currentTime reads the transaction timestamp and fails if the peer left it unset.
+func (ac *DoubleAuctionContract) currentTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current block timestamp: %v", err)
+	}
+	if timestamp == nil {
+		return 0, fmt.Errorf("transaction timestamp is not set")
+	}
+	return timestamp.Seconds, nil
+}
+
+func (ac *DoubleAuctionContract) toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (ac *DoubleAuctionContract) checkRole(ctx contractapi.TransactionContextInterface, role string) error {
+	clientRole, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to get client role: %v", err)
+	}
+	if !found || !strings.EqualFold(clientRole, role) {
+		return fmt.Errorf("client is not authorized: %s role required", role)
+	}
+	return nil
+}
+
+func (ac *DoubleAuctionContract) submitOrder(ctx contractapi.TransactionContextInterface, objectType, orderID string, volume, price float64) error {
+	if orderID == "" {
+		return fmt.Errorf("order ID must not be empty")
+	}
+
+	if volume <= 0 {
+		return fmt.Errorf("order volume must be greater than zero")
+	}
+
+	if price < 0 {
+		return fmt.Errorf("order price must not be negative")
+	}
+
+	for _, existingType := range []string{askObjectType, bidObjectType} {
+		existingOrder, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, existingType, orderID))
+		if err != nil {
+			return fmt.Errorf("failed to interact with world state: %v", err)
+		}
+		if existingOrder != nil {
+			return fmt.Errorf("an order already exists with ID: %s", orderID)
+		}
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	order := Order{
+		OrderID:   orderID,
+		Trader:    clientID,
+		Volume:    volume,
+		Price:     ac.toMinorUnits(price),
+		Timestamp: currentTime,
+	}
+
+	return ac.storeObject(ctx, ac.createCompositeKey(ctx, objectType, orderID), order)
+}
+
+func (ac *DoubleAuctionContract) fetchOrders(ctx contractapi.TransactionContextInterface, objectType string) ([]Order, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve orders: %v", err)
+	}
+	defer results.Close()
+
+	orders := []Order{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var order Order
+		if err := json.Unmarshal(next.Value, &order); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal order: %v", err)
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// updateBook removes the filled orders before index filled and rewrites the remaining ones,
+// collecting them as the unmatched residual.
+func (ac *DoubleAuctionContract) updateBook(ctx contractapi.TransactionContextInterface, objectType string, orders []Order, filled int, updates map[string][]byte, residual *[]Order) error {
+	for k, order := range orders {
+		orderKey := ac.createCompositeKey(ctx, objectType, order.OrderID)
+		if k < filled {
+			if err := ctx.GetStub().DelState(orderKey); err != nil {
+				return fmt.Errorf("failed to delete filled order: %v", err)
+			}
+			continue
+		}
+
+		orderJSON, err := json.Marshal(order)
+		if err != nil {
+			return fmt.Errorf("failed to marshal order: %v", err)
+		}
+		updates[orderKey] = orderJSON
+		*residual = append(*residual, order)
+	}
+	return nil
+}
+
+func (ac *DoubleAuctionContract) storeObject(ctx contractapi.TransactionContextInterface, key string, object interface{}) error {
+	objectJSON, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object: %v", err)
+	}
+	return ctx.GetStub().PutState(key, objectJSON)
+}
+
+func (ac *DoubleAuctionContract) batchStore(ctx contractapi.TransactionContextInterface, updates map[string][]byte) error {
+	for key, value := range updates {
+		if err := ctx.GetStub().PutState(key, value); err != nil {
+			return fmt.Errorf("failed to update state for key %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func (ac *DoubleAuctionContract) createCompositeKey(ctx contractapi.TransactionContextInterface, objectType string, objectAttributes ...string) string {
+	key, _ := ctx.GetStub().CreateCompositeKey(objectType, objectAttributes)
+	return key
+}
+
+func main() {
+	chaincode, err := contractapi.NewChaincode(&DoubleAuctionContract{})
+	if err != nil {
+		log.Panicf("Error creating asset chaincode: %v", err)
+	}
+
+	if err := chaincode.Start(); err != nil {
+		log.Panicf("Error starting asset chaincode: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type testIdentity struct {
+	id   string
+	role string
+}
+
+func (ti testIdentity) GetID() (string, error) {
+	return ti.id, nil
+}
+
+func (ti testIdentity) GetMSPID() (string, error) {
+	return "Org1MSP", nil
+}
+
+func (ti testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	if attrName == roleAttribute && ti.role != "" {
+		return ti.role, true, nil
+	}
+	return "", false, nil
+}
+
+func (ti testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	return nil
+}
+
+func (ti testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{}, nil
+}
+
+func newTestContext(stub *shimtest.MockStub, clientID, role string) *contractapi.TransactionContext {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(testIdentity{id: clientID, role: role})
+	return ctx
+}
+
+// testOrder is an ask, or a bid when isBid is set, placed before the market is cleared.
+type testOrder struct {
+	orderID string
+	volume  float64
+	price   float64
+	isBid   bool
+}
+
+// clearTestMarket submits the given orders and clears the market in a later transaction.
+func clearTestMarket(t *testing.T, orders []testOrder) (*DoubleAuctionContract, *shimtest.MockStub, *MarketClearing) {
+	t.Helper()
+	ac := new(DoubleAuctionContract)
+	stub := shimtest.NewMockStub("double_auction", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, order := range orders {
+		var err error
+		if order.isBid {
+			err = ac.SubmitBid(newTestContext(stub, "consumer-"+order.orderID, consumerRole), order.orderID, order.volume, order.price)
+		} else {
+			err = ac.SubmitAsk(newTestContext(stub, "producer-"+order.orderID, producerRole), order.orderID, order.volume, order.price)
+		}
+		if err != nil {
+			t.Fatalf("submitting order %s failed: %v", order.orderID, err)
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	clearing, err := ac.ClearMarket(newTestContext(stub, "operator", ""))
+	if err != nil {
+		t.Fatalf("ClearMarket failed: %v", err)
+	}
+	stub.MockTransactionEnd("tx2")
+
+	return ac, stub, clearing
+}
+
+func TestClearMarketPartialFillLeavesResidualOnBothSides(t *testing.T) {
+	ac, stub, clearing := clearTestMarket(t, []testOrder{
+		{orderID: "ask1", volume: 10, price: 5},
+		{orderID: "ask2", volume: 10, price: 9},
+		{orderID: "bid1", volume: 6, price: 8, isBid: true},
+		{orderID: "bid2", volume: 4, price: 4, isBid: true},
+	})
+
+	if len(clearing.Trades) != 1 || clearing.Trades[0].AskID != "ask1" || clearing.Trades[0].BidID != "bid1" || clearing.Trades[0].Volume != 6 {
+		t.Fatalf("expected a single trade of 6 between ask1 and bid1, got %+v", clearing.Trades)
+	}
+
+	if len(clearing.UnmatchedAsks) != 2 || clearing.UnmatchedAsks[0].OrderID != "ask1" || clearing.UnmatchedAsks[0].Volume != 4 {
+		t.Errorf("expected ask1 to keep 4 units next to ask2, got %+v", clearing.UnmatchedAsks)
+	}
+	if len(clearing.UnmatchedBids) != 1 || clearing.UnmatchedBids[0].OrderID != "bid2" || clearing.UnmatchedBids[0].Volume != 4 {
+		t.Errorf("expected bid2 to stay unmatched with 4 units, got %+v", clearing.UnmatchedBids)
+	}
+
+	stub.MockTransactionStart("tx3")
+	defer stub.MockTransactionEnd("tx3")
+	ctx := newTestContext(stub, "operator", "")
+	asks, err := ac.fetchOrders(ctx, askObjectType)
+	if err != nil {
+		t.Fatalf("fetchOrders(asks) failed: %v", err)
+	}
+	bids, err := ac.fetchOrders(ctx, bidObjectType)
+	if err != nil {
+		t.Fatalf("fetchOrders(bids) failed: %v", err)
+	}
+	if len(asks) != 2 || len(bids) != 1 {
+		t.Errorf("expected the book to keep 2 asks and 1 bid, got %+v and %+v", asks, bids)
+	}
+}
+
+func TestClearMarketWithoutCrossingOrders(t *testing.T) {
+	_, _, clearing := clearTestMarket(t, []testOrder{
+		{orderID: "ask1", volume: 5, price: 10},
+		{orderID: "bid1", volume: 5, price: 6, isBid: true},
+	})
+
+	if len(clearing.Trades) != 0 {
+		t.Errorf("expected no trades, got %+v", clearing.Trades)
+	}
+	if clearing.ClearingPrice != 0 {
+		t.Errorf("expected a zero clearing price, got %d", clearing.ClearingPrice)
+	}
+	if len(clearing.UnmatchedAsks) != 1 || len(clearing.UnmatchedBids) != 1 {
+		t.Errorf("expected both orders to stay unmatched, got %+v and %+v", clearing.UnmatchedAsks, clearing.UnmatchedBids)
+	}
+}
+
+func TestClearMarketPricesAtMidpointOfMarginalOrders(t *testing.T) {
+	_, _, clearing := clearTestMarket(t, []testOrder{
+		{orderID: "ask1", volume: 5, price: 3},
+		{orderID: "ask2", volume: 5, price: 4},
+		{orderID: "bid1", volume: 5, price: 7, isBid: true},
+		{orderID: "bid2", volume: 5, price: 6, isBid: true},
+	})
+
+	// The last trade matches ask2 at 4 with bid2 at 6.
+	if clearing.ClearingPrice != 500 {
+		t.Fatalf("expected the midpoint of 400 and 600, got %d", clearing.ClearingPrice)
+	}
+	for _, trade := range clearing.Trades {
+		if trade.Price != clearing.ClearingPrice {
+			t.Errorf("expected every trade at the clearing price, got %+v", trade)
+		}
+	}
+}
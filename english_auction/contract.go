@@ -4,42 +4,161 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"sort"
+	"strings"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/khalidzahra/contractinfo"
 )
 
 type EnergyResource struct {
 	Volume        float64 `json:"volume"`
-	Price         float64 `json:"price"`
+	Price         int64   `json:"priceMinor"`
 	Type          string  `json:"type"`
 	IsAvailable   bool    `json:"isAvailable"`
 	AuctionStatus bool    `json:"auctionStatus"`
+	Owner         string  `json:"owner"`
 }
 
 type EnergyAuction struct {
-	ResourceID    string  `json:"resourceID"`
-	Deadline      int64   `json:"deadline"`
-	HighestBid    float64 `json:"highestBid"`
-	HighestBidder string  `json:"highestBidder"`
-	IsActive      bool    `json:"status"`
+	ResourceID    string     `json:"resourceID"`
+	Deadline      int64      `json:"deadline"`
+	HighestBid    int64      `json:"highestBidMinor"`
+	HighestBidder string     `json:"highestBidder"`
+	MinIncrement  int64      `json:"minIncrementMinor"`
+	PreviousBids  []PriorBid `json:"previousBids"`
+	Version       int64      `json:"version"`
+	IsActive      bool       `json:"status"`
 }
 
+type AuctionTimeRemaining struct {
+	SecondsRemaining int64 `json:"secondsRemaining"`
+	Expired          bool  `json:"expired"`
+}
+
+type MarketDepth struct {
+	HighestBid         int64  `json:"highestBidMinor"`
+	HighestBidder      string `json:"highestBidder"`
+	HasBidder          bool   `json:"hasBidder"`
+	ResourceFloorPrice int64  `json:"resourceFloorPriceMinor"`
+	NextMinimumBid     int64  `json:"nextMinimumBidMinor"`
+}
+
+type ClientIdentityInfo struct {
+	ClientID string `json:"clientID"`
+	MSPID    string `json:"mspID"`
+	Subject  string `json:"subject"`
+}
+
+type PriorBid struct {
+	Bidder string `json:"bidder"`
+	Amount int64  `json:"amountMinor"`
+}
+
+// ResourceLock marks a resource as taken by an auction of AuctionType.
+type ResourceLock struct {
+	ResourceID  string `json:"resourceID"`
+	AuctionType string `json:"auctionType"`
+}
+
+const (
+	lockKeyPrefix          = "lock:"
+	lockPeersKey           = "config:lockPeers"
+	englishAuctionType     = "english"
+	secondPriceAuctionType = "second-price"
+)
+
+// Clients carrying this attribute set to "true" may manage the lock of any resource and choose
+// the lock peers.
+const adminAttribute = "admin"
+
+// Prices, bids and increments are held in cents so bid comparisons are exact.
+const minorUnitsPerUnit = 100
+
+// Older resources and auctions kept float prices and bids under "price" and "highestBid";
+// the UnmarshalJSON methods below convert them on read.
+func legacyMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (r *EnergyResource) UnmarshalJSON(data []byte) error {
+	type stored EnergyResource
+	legacy := struct {
+		*stored
+		LegacyPrice *float64 `json:"price"`
+	}{stored: (*stored)(r)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyPrice != nil {
+		r.Price = legacyMinorUnits(*legacy.LegacyPrice)
+	}
+	return nil
+}
+
+func (a *EnergyAuction) UnmarshalJSON(data []byte) error {
+	type stored EnergyAuction
+	legacy := struct {
+		*stored
+		LegacyHighestBid *float64 `json:"highestBid"`
+	}{stored: (*stored)(a)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyHighestBid != nil {
+		a.HighestBid = legacyMinorUnits(*legacy.LegacyHighestBid)
+	}
+	return nil
+}
+
+const contractVersion = "1.2.0"
+
 type EnergyAuctionContract struct {
 	contractapi.Contract
 }
 
+// GetContractInfo reports the open ascending English auction; bids are public and there is no
+// reserve beyond the resource price.
+func (ac *EnergyAuctionContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*contractinfo.ContractInfo, error) {
+	return &contractinfo.ContractInfo{
+		AuctionType:     englishAuctionType,
+		Version:         contractVersion,
+		SupportsReserve: false,
+		SupportsSealed:  false,
+	}, nil
+}
+
 func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType string) error {
+	if energyVolume <= 0 {
+		return fmt.Errorf("energy volume must be greater than zero")
+	}
+
+	if energyPrice < 0 {
+		return fmt.Errorf("energy price must not be negative")
+	}
+
+	if resourceType == "" {
+		return fmt.Errorf("resource type must not be empty")
+	}
+
 	if err := ac.checkResourceExists(ctx, resourceID); err != nil {
 		return err
 	}
 
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
 	resource := EnergyResource{
 		Volume:        energyVolume,
-		Price:         energyPrice,
+		Price:         ac.toMinorUnits(energyPrice),
 		Type:          resourceType,
 		IsAvailable:   true,
 		AuctionStatus: false,
+		Owner:         clientID,
 	}
 
 	return ac.storeObject(ctx, resourceID, resource)
@@ -53,6 +172,36 @@ func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextI
 	return ac.marshalToString(resource)
 }
 
+func (ac *EnergyAuctionContract) GetResourceStruct(ctx contractapi.TransactionContextInterface, resourceID string) (*EnergyResource, error) {
+	return ac.fetchResource(ctx, resourceID)
+}
+
+func (ac *EnergyAuctionContract) WhoAmI(ctx contractapi.TransactionContextInterface) (*ClientIdentityInfo, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("client identity has no X509 certificate")
+	}
+
+	return &ClientIdentityInfo{
+		ClientID: clientID,
+		MSPID:    mspID,
+		Subject:  cert.Subject.String(),
+	}, nil
+}
+
 func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContextInterface) ([]EnergyResource, error) {
 	results, err := ctx.GetStub().GetStateByRange("", "")
 	if err != nil {
@@ -67,6 +216,10 @@ func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContex
 			return nil, err
 		}
 
+		if !ac.isResourceKey(next.Key) {
+			continue
+		}
+
 		var resource EnergyResource
 		err = json.Unmarshal(next.Value, &resource)
 		if err != nil { // Not an EnergyResource object
@@ -75,14 +228,93 @@ func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContex
 		resources = append(resources, resource)
 	}
 
-	sort.Slice(resources, func(i, j int) bool {
+	sort.SliceStable(resources, func(i, j int) bool {
 		return resources[i].Price < resources[j].Price
 	})
 
 	return resources, nil
 }
 
-func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration int64) error {
+// LockResource reserves a resource for an auction type. Only the resource owner or an admin
+// may take the lock.
+func (ac *EnergyAuctionContract) LockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	if auctionType != englishAuctionType && auctionType != secondPriceAuctionType {
+		return fmt.Errorf("auction type must be %q or %q", englishAuctionType, secondPriceAuctionType)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkLockAccess(ctx, resourceID, resource); err != nil {
+		return err
+	}
+
+	return ac.lockResource(ctx, resourceID, auctionType)
+}
+
+// UnlockResource releases the lock of a resource that is not in an auction. Only the resource
+// owner or an admin may release it; a lock left behind by a deleted resource needs an admin.
+func (ac *EnergyAuctionContract) UnlockResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedResource, err := ctx.GetStub().GetState(resourceID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve resource: %v", err)
+	}
+
+	var resource *EnergyResource
+	if fetchedResource != nil {
+		resource = &EnergyResource{}
+		if err := json.Unmarshal(fetchedResource, resource); err != nil {
+			return fmt.Errorf("failed to unmarshal resource: %v", err)
+		}
+	}
+
+	if err := ac.checkLockAccess(ctx, resourceID, resource); err != nil {
+		return err
+	}
+
+	if resource != nil && resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is still active", resourceID)
+	}
+
+	return ctx.GetStub().DelState(lockKeyPrefix + resourceID)
+}
+
+// GetResourceLock returns the auction type holding the lock of a resource, or an empty string
+// when it is not locked. Lock peers call it through InvokeChaincode.
+func (ac *EnergyAuctionContract) GetResourceLock(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return "", err
+	}
+	if lock == nil {
+		return "", nil
+	}
+	return lock.AuctionType, nil
+}
+
+// SetLockPeers names the chaincodes on this channel whose resource locks must be free before
+// an auction starts here. An empty list stops consulting other chaincodes.
+func (ac *EnergyAuctionContract) SetLockPeers(ctx contractapi.TransactionContextInterface, chaincodeNames []string) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("client is not authorized: admin attribute required")
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		if chaincodeName == "" {
+			return fmt.Errorf("chaincode name must not be empty")
+		}
+	}
+
+	return ac.storeObject(ctx, lockPeersKey, chaincodeNames)
+}
+
+func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration int64, minIncrement float64) error {
+	if minIncrement < 0 {
+		return fmt.Errorf("minimum bid increment must not be negative")
+	}
+
 	resource, err := ac.fetchResource(ctx, resourceID)
 
 	if err != nil {
@@ -97,16 +329,22 @@ func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContext
 		return fmt.Errorf("resource with ID %s is not available", resourceID)
 	}
 
-	currentTimeStamp, err := ctx.GetStub().GetTxTimestamp()
+	if err := ac.lockResource(ctx, resourceID, englishAuctionType); err != nil {
+		return err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
 	auction := EnergyAuction{
 		ResourceID:    resourceID,
-		Deadline:      currentTimeStamp.Seconds + duration,
+		Deadline:      currentTime + duration,
 		HighestBid:    0,
 		HighestBidder: "",
+		MinIncrement:  ac.toMinorUnits(minIncrement),
+		PreviousBids:  []PriorBid{},
 		IsActive:      true,
 	}
 
@@ -126,7 +364,65 @@ func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextIn
 	return ac.marshalToString(auction)
 }
 
-func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount float64) error {
+func (ac *EnergyAuctionContract) GetAuctionTimeRemaining(ctx contractapi.TransactionContextInterface, resourceID string) (*AuctionTimeRemaining, error) {
+	auction, err := ac.fetchAuction(ctx, "auction:"+resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := auction.Deadline - currentTime
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &AuctionTimeRemaining{
+		SecondsRemaining: remaining,
+		Expired:          auction.Deadline < currentTime,
+	}, nil
+}
+
+func (ac *EnergyAuctionContract) GetMarketDepth(ctx contractapi.TransactionContextInterface, resourceID string) (*MarketDepth, error) {
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	auction, err := ac.fetchAuction(ctx, "auction:"+resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A bid must beat both the floor price and the current highest bid, and clear the increment.
+	nextMinimumBid := max(resource.Price, auction.HighestBid) + 1
+	if auction.HighestBid > 0 {
+		nextMinimumBid = max(nextMinimumBid, auction.HighestBid+auction.MinIncrement)
+	} else {
+		nextMinimumBid = max(nextMinimumBid, resource.Price+auction.MinIncrement)
+	}
+
+	depth := MarketDepth{
+		HighestBid:         auction.HighestBid,
+		HasBidder:          auction.HighestBidder != "",
+		ResourceFloorPrice: resource.Price,
+		NextMinimumBid:     nextMinimumBid,
+	}
+
+	// The leading bidder stays anonymous until the auction has been ended.
+	if !auction.IsActive {
+		depth.HighestBidder = auction.HighestBidder
+	}
+
+	return &depth, nil
+}
+
+// expectedVersion must match the auction's current Version, so a bid placed against a stale view
+// of the highest bid is rejected and has to be resubmitted.
+func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount float64, expectedVersion int64) error {
 	auctionID := "auction:" + resourceID
 
 	resource, err := ac.fetchResource(ctx, resourceID)
@@ -143,31 +439,143 @@ func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	currentTimeStamp, err := ctx.GetStub().GetTxTimestamp()
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	if auction.Deadline < currentTimeStamp.Seconds {
+	if auction.Deadline < currentTime {
 		return ac.EndAuction(ctx, resourceID)
 	}
 
-	if bidAmount <= resource.Price {
+	clientId, err := ctx.GetClientIdentity().GetID()
+
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientId == resource.Owner {
+		return fmt.Errorf("resource owner cannot bid on their own auction")
+	}
+
+	if expectedVersion != auction.Version {
+		return fmt.Errorf("auction with ID %s has changed since version %d (now %d), resubmit the bid", auctionID, expectedVersion, auction.Version)
+	}
+
+	bidUnits := ac.toMinorUnits(bidAmount)
+
+	if bidUnits <= resource.Price {
 		return fmt.Errorf("bid amount must be higher than resource price")
 	}
 
-	if bidAmount <= auction.HighestBid {
+	if bidUnits <= auction.HighestBid {
 		return fmt.Errorf("bid amount must be higher than current highest bid")
 	}
 
+	minimumBid := resource.Price + auction.MinIncrement
+	if auction.HighestBid > 0 {
+		minimumBid = auction.HighestBid + auction.MinIncrement
+	}
+
+	if bidUnits < minimumBid {
+		return fmt.Errorf("bid amount must be at least %.2f", ac.fromMinorUnits(minimumBid))
+	}
+
+	if auction.HighestBidder != "" {
+		auction.PreviousBids = append(auction.PreviousBids, PriorBid{Bidder: auction.HighestBidder, Amount: auction.HighestBid})
+	}
+
+	auction.HighestBid = bidUnits
+	auction.HighestBidder = clientId
+	auction.Version++
+
+	return ac.storeObject(ctx, auctionID, *auction)
+}
+
+func (ac *EnergyAuctionContract) ExtendAuction(ctx contractapi.TransactionContextInterface, resourceID string, extraSeconds int64) error {
+	auctionID := "auction:" + resourceID
+
+	if extraSeconds <= 0 {
+		return fmt.Errorf("extension must be greater than zero seconds")
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
 	clientId, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientId != resource.Owner {
+		return fmt.Errorf("only the resource owner can extend the auction")
+	}
+
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline < currentTime {
+		return fmt.Errorf("auction with ID %s has expired", auctionID)
+	}
+
+	auction.Deadline += extraSeconds
+
+	return ac.storeObject(ctx, auctionID, *auction)
+}
+
+func (ac *EnergyAuctionContract) WithdrawBid(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	auctionID := "auction:" + resourceID
+
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
+	}
 
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline < currentTime {
+		return fmt.Errorf("auction with ID %s has expired", auctionID)
+	}
+
+	clientId, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
 		return fmt.Errorf("failed to get client ID: %v", err)
 	}
 
-	auction.HighestBid = bidAmount
-	auction.HighestBidder = clientId
+	if auction.HighestBidder != clientId {
+		return fmt.Errorf("only the current highest bidder can withdraw their bid")
+	}
+
+	if len(auction.PreviousBids) == 0 {
+		auction.HighestBid = 0
+		auction.HighestBidder = ""
+	} else {
+		previous := auction.PreviousBids[len(auction.PreviousBids)-1]
+		auction.PreviousBids = auction.PreviousBids[:len(auction.PreviousBids)-1]
+		auction.HighestBid = previous.Amount
+		auction.HighestBidder = previous.Bidder
+	}
+	auction.Version++
 
 	return ac.storeObject(ctx, auctionID, *auction)
 }
@@ -184,18 +592,18 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	currentTimeStamp, err := ctx.GetStub().GetTxTimestamp()
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	if auction.Deadline > currentTimeStamp.Seconds {
+	if auction.Deadline > currentTime {
 		return fmt.Errorf("auction with ID %s has not yet expired", auctionID)
 	}
 
 	winner := auction.HighestBidder
 	winningBid := auction.HighestBid
-	fmt.Printf("auction has been ended. Winner: %s with a bid of: %f\n", winner, winningBid)
+	fmt.Printf("auction has been ended. Winner: %s with a bid of: %.2f\n", winner, ac.fromMinorUnits(winningBid))
 
 	auction.IsActive = false
 	ac.storeObject(ctx, auctionID, *auction)
@@ -213,10 +621,120 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 
 	ac.storeObject(ctx, resourceID, *resource)
 
+	if err := ctx.GetStub().DelState(lockKeyPrefix + resourceID); err != nil {
+		return fmt.Errorf("failed to release resource lock: %v", err)
+	}
+
 	return ac.storeObject(ctx, auctionID, *auction)
 }
 
 // Helper functions
+
+// currentTime returns the transaction time in Unix seconds, or an error when it is unset.
+func (ac *EnergyAuctionContract) currentTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current block timestamp: %v", err)
+	}
+	if timestamp == nil {
+		return 0, fmt.Errorf("transaction timestamp is not set")
+	}
+	return timestamp.Seconds, nil
+}
+
+func (ac *EnergyAuctionContract) toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (ac *EnergyAuctionContract) fromMinorUnits(units int64) float64 {
+	return float64(units) / minorUnitsPerUnit
+}
+
+// lockResource takes the lock for auctionType unless this chaincode or one of its lock peers
+// already holds a lock on the resource. Taking a lock this chaincode already holds for the
+// same type succeeds.
+func (ac *EnergyAuctionContract) lockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		if lock.AuctionType != auctionType {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction", resourceID, lock.AuctionType)
+		}
+		return nil
+	}
+
+	if err := ac.checkPeerLocks(ctx, resourceID); err != nil {
+		return err
+	}
+
+	return ac.storeObject(ctx, lockKeyPrefix+resourceID, ResourceLock{ResourceID: resourceID, AuctionType: auctionType})
+}
+
+// checkPeerLocks fails if any lock peer holds a lock on the resource. Reads made through
+// InvokeChaincode on the same channel join this transaction's read set, so a peer lock taken
+// concurrently invalidates one of the two transactions.
+func (ac *EnergyAuctionContract) checkPeerLocks(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedPeers, err := ctx.GetStub().GetState(lockPeersKey)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve lock peers: %v", err)
+	}
+	if fetchedPeers == nil {
+		return nil
+	}
+
+	var chaincodeNames []string
+	if err := json.Unmarshal(fetchedPeers, &chaincodeNames); err != nil {
+		return fmt.Errorf("failed to unmarshal lock peers: %v", err)
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		response := ctx.GetStub().InvokeChaincode(chaincodeName, [][]byte{[]byte("GetResourceLock"), []byte(resourceID)}, "")
+		if response.Status != shim.OK {
+			return fmt.Errorf("failed to query resource lock in chaincode %s: %s", chaincodeName, response.Message)
+		}
+		if auctionType := string(response.Payload); auctionType != "" {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction in chaincode %s", resourceID, auctionType, chaincodeName)
+		}
+	}
+	return nil
+}
+
+// checkLockAccess lets the resource owner and admins manage a resource lock. Resources stored
+// without an owner, or no longer stored at all, can only be managed by an admin.
+func (ac *EnergyAuctionContract) checkLockAccess(ctx contractapi.TransactionContextInterface, resourceID string, resource *EnergyResource) error {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if resource != nil && resource.Owner != "" && resource.Owner == clientID {
+		return nil
+	}
+
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("only the owner of resource with ID %s or an admin can manage its lock", resourceID)
+	}
+	return nil
+}
+
+func (ac *EnergyAuctionContract) fetchLock(ctx contractapi.TransactionContextInterface, resourceID string) (*ResourceLock, error) {
+	fetchedLock, err := ctx.GetStub().GetState(lockKeyPrefix + resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resource lock: %v", err)
+	}
+	if fetchedLock == nil {
+		return nil, nil
+	}
+
+	var lock ResourceLock
+	if err := json.Unmarshal(fetchedLock, &lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource lock: %v", err)
+	}
+	return &lock, nil
+}
+
 func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error) {
 	jsonData, err := json.Marshal(v)
 	if err != nil {
@@ -276,6 +794,11 @@ func (ac *EnergyAuctionContract) storeObject(ctx contractapi.TransactionContextI
 	return ctx.GetStub().PutState(key, objectJSON)
 }
 
+// Resources share the key space with auction, lock and config records, so range scans must skip those.
+func (ac *EnergyAuctionContract) isResourceKey(key string) bool {
+	return !strings.HasPrefix(key, "auction:") && !strings.HasPrefix(key, lockKeyPrefix) && key != lockPeersKey
+}
+
 func main() {
 	chaincode, err := contractapi.NewChaincode(&EnergyAuctionContract{})
 	if err != nil {
@@ -2,6 +2,8 @@ module github.com/khalidzahra/energy_auction
 
 go 1.22.4
 
+require github.com/khalidzahra/contractinfo v0.0.0
+
 require github.com/hyperledger/fabric-contract-api-go v1.2.2
 
 require (
@@ -31,3 +33,5 @@ require (
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/khalidzahra/contractinfo => ../contractinfo
@@ -4,47 +4,245 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"sort"
+	"strings"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/khalidzahra/contractinfo"
 )
 
 type EnergyResource struct {
 	Volume        float64 `json:"volume"`
-	Price         float64 `json:"price"`
+	Price         int64   `json:"priceMinor"`
 	Type          string  `json:"type"`
 	IsAvailable   bool    `json:"isAvailable"`
 	AuctionStatus bool    `json:"auctionStatus"`
+	Owner         string  `json:"owner"`
+	DeliveryStart int64   `json:"deliveryStart"`
+	DeliveryEnd   int64   `json:"deliveryEnd"`
 }
 
 type EnergyAuction struct {
-	ResourceID    string  `json:"resourceID"`
-	Deadline      int64   `json:"deadline"`
-	HighestBid    float64 `json:"highestBid"`
-	HighestBidder string  `json:"highestBidder"`
-	IsActive      bool    `json:"status"`
+	ResourceID        string            `json:"resourceID"`
+	Deadline          int64             `json:"deadline"`
+	HighestBid        int64             `json:"highestBidMinor"`
+	HighestBidder     string            `json:"highestBidder"`
+	MinIncrement      int64             `json:"minIncrementMinor"`
+	IncrementPercent  float64           `json:"incrementPercent"`
+	ExtensionWindow   int64             `json:"extensionWindow"`
+	MaxExtensions     int               `json:"maxExtensions"`
+	ExtensionCount    int               `json:"extensionCount"`
+	BuyNowPrice       int64             `json:"buyNowPriceMinor"`
+	MinDeposit        int64             `json:"minDepositMinor"`
+	AllowedMSP        string            `json:"allowedMSP"`
+	TerminationReason string            `json:"terminationReason"`
+	Outcome           string            `json:"outcome"`
+	Unit              string            `json:"unit"`
+	CloseMode         string            `json:"closeMode"`
+	BidHistory        []BidHistoryEntry `json:"bidHistory"`
+	IsActive          bool              `json:"status"`
+}
+
+type BidHistoryEntry struct {
+	Bidder    string `json:"bidder"`
+	Amount    int64  `json:"amountMinor"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AuctionView reports RemainingExtensions as -1 for soft-close auctions without an extension cap.
+type AuctionView struct {
+	EnergyAuction
+	RemainingExtensions int    `json:"remainingExtensions"`
+	CloseRule           string `json:"closeRule"`
+}
+
+type AuctionForceEndedEvent struct {
+	ResourceID string `json:"resourceID"`
+	Reason     string `json:"reason"`
+}
+
+type EscrowDeposit struct {
+	ResourceID string `json:"resourceID"`
+	Depositor  string `json:"depositor"`
+	Amount     int64  `json:"amountMinor"`
+	Refunded   bool   `json:"refunded"`
+}
+
+type AuctionStartedEvent struct {
+	ResourceID string `json:"resourceID"`
+	Deadline   int64  `json:"deadline"`
+	Unit       string `json:"unit"`
+}
+
+type AuctionEndedEvent struct {
+	ResourceID string `json:"resourceID"`
+	Winner     string `json:"winner"`
+	Price      int64  `json:"priceMinor"`
+}
+
+type AuctionUnsoldEvent struct {
+	ResourceID string `json:"resourceID"`
+}
+
+type ResourceUpdatedEvent struct {
+	ResourceID string  `json:"resourceID"`
+	OldVolume  float64 `json:"oldVolume"`
+	NewVolume  float64 `json:"newVolume"`
 }
 
 const (
 	resourceObjectType = "resource"
 	auctionObjectType  = "auction"
+	depositObjectType  = "deposit"
+	lockObjectType     = "lock"
+	configObjectType   = "config"
+)
+
+const (
+	roleAttribute = "role"
+	producerRole  = "producer"
+	consumerRole  = "consumer"
+)
+
+// Outcome is empty while an auction runs and set to one of these once it has ended.
+const (
+	outcomeSold   = "sold"
+	outcomeUnsold = "unsold"
+)
+
+// Clients carrying this attribute set to "true" may force-end auctions, manage the lock of any
+// resource and choose the lock peers.
+const adminAttribute = "admin"
+
+const (
+	minAuctionDuration = 60
+	maxAuctionDuration = 30 * 24 * 60 * 60
+)
+
+// A hard-close auction rejects every bid after its deadline. A soft-close auction extends its
+// deadline when bids arrive within the extension window. Auctions stored without a close mode
+// are soft-close.
+const (
+	closeModeHard = "hard"
+	closeModeSoft = "soft"
+)
+
+// Units an auction may quote prices in. Bid amounts are read in the unit of their auction.
+var allowedUnits = map[string]bool{
+	"USD/MWh": true,
+	"USD/kWh": true,
+	"EUR/MWh": true,
+	"EUR/kWh": true,
+}
+
+// Prices, bids, deposits and fixed increments are held in cents.
+const minorUnitsPerUnit = 100
+
+// legacyMinorUnits converts the float "price" and "highestBid" values of records written
+// before amounts were held in cents.
+func legacyMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (r *EnergyResource) UnmarshalJSON(data []byte) error {
+	type stored EnergyResource
+	legacy := struct {
+		*stored
+		LegacyPrice *float64 `json:"price"`
+	}{stored: (*stored)(r)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyPrice != nil {
+		r.Price = legacyMinorUnits(*legacy.LegacyPrice)
+	}
+	return nil
+}
+
+func (a *EnergyAuction) UnmarshalJSON(data []byte) error {
+	type stored EnergyAuction
+	legacy := struct {
+		*stored
+		LegacyHighestBid *float64 `json:"highestBid"`
+	}{stored: (*stored)(a)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyHighestBid != nil {
+		a.HighestBid = legacyMinorUnits(*legacy.LegacyHighestBid)
+	}
+	return nil
+}
+
+const contractVersion = "1.2.0"
+
+// ResourceLock keeps the lock peers from auctioning a resource while an English auction runs on it.
+type ResourceLock struct {
+	ResourceID  string `json:"resourceID"`
+	AuctionType string `json:"auctionType"`
+}
+
+const (
+	englishAuctionType     = "english"
+	secondPriceAuctionType = "second-price"
 )
 
 type EnergyAuctionContract struct {
 	contractapi.Contract
 }
 
-func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType string) error {
+// GetContractInfo identifies this optimized English auction. Deposits and buy-now prices are
+// not reported as reserves.
+func (ac *EnergyAuctionContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*contractinfo.ContractInfo, error) {
+	return &contractinfo.ContractInfo{
+		AuctionType:     englishAuctionType,
+		Version:         contractVersion,
+		SupportsReserve: false,
+		SupportsSealed:  false,
+	}, nil
+}
+
+func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType string, deliveryStart, deliveryEnd int64) error {
+	if err := ac.checkRole(ctx, producerRole); err != nil {
+		return err
+	}
+
+	if energyVolume <= 0 {
+		return fmt.Errorf("energy volume must be greater than zero")
+	}
+
+	if energyPrice < 0 {
+		return fmt.Errorf("energy price must not be negative")
+	}
+
+	if resourceType == "" {
+		return fmt.Errorf("resource type must not be empty")
+	}
+
+	if deliveryStart >= deliveryEnd {
+		return fmt.Errorf("delivery start must be before delivery end")
+	}
+
 	if err := ac.checkResourceExists(ctx, resourceID); err != nil {
 		return err
 	}
 
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
 	resource := EnergyResource{
 		Volume:        energyVolume,
-		Price:         energyPrice,
+		Price:         ac.toMinorUnits(energyPrice),
 		Type:          resourceType,
 		IsAvailable:   true,
 		AuctionStatus: false,
+		Owner:         clientID,
+		DeliveryStart: deliveryStart,
+		DeliveryEnd:   deliveryEnd,
 	}
 
 	return ac.storeResource(ctx, resourceID, resource)
@@ -58,6 +256,43 @@ func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextI
 	return ac.marshalToString(resource)
 }
 
+func (ac *EnergyAuctionContract) UpdateResourceVolume(ctx contractapi.TransactionContextInterface, resourceID string, newVolume float64) error {
+	if newVolume <= 0 {
+		return fmt.Errorf("energy volume must be greater than zero")
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientID != resource.Owner {
+		return fmt.Errorf("only the owner of resource with ID %s can update it", resourceID)
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("resource with ID %s is currently in an auction", resourceID)
+	}
+
+	oldVolume := resource.Volume
+	resource.Volume = newVolume
+
+	if err := ac.storeResource(ctx, resourceID, *resource); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(ResourceUpdatedEvent{ResourceID: resourceID, OldVolume: oldVolume, NewVolume: newVolume})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return ctx.GetStub().SetEvent("ResourceUpdated", eventJSON)
+}
+
 func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContextInterface) ([]EnergyResource, error) {
 	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
 	if err != nil {
@@ -80,7 +315,7 @@ func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContex
 		resources = append(resources, resource)
 	}
 
-	sort.Slice(resources, func(i, j int) bool {
+	sort.SliceStable(resources, func(i, j int) bool {
 		return resources[i].Price < resources[j].Price
 	})
 
@@ -118,7 +353,130 @@ func (ac *EnergyAuctionContract) GetMeritOrderPaginated(ctx contractapi.Transact
 	return resources, metadata.Bookmark, nil
 }
 
-func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration int64) error {
+// LockResource reserves a resource for an auction type. Only the resource owner or an admin
+// may take the lock.
+func (ac *EnergyAuctionContract) LockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	if auctionType != englishAuctionType && auctionType != secondPriceAuctionType {
+		return fmt.Errorf("auction type must be %q or %q", englishAuctionType, secondPriceAuctionType)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkLockAccess(ctx, resourceID, resource); err != nil {
+		return err
+	}
+
+	return ac.lockResource(ctx, resourceID, auctionType)
+}
+
+// UnlockResource releases the lock of a resource that is not in an auction. Only the resource
+// owner or an admin may release it; a lock left behind by a deleted resource needs an admin.
+func (ac *EnergyAuctionContract) UnlockResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedResource, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, resourceObjectType, resourceID))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve resource: %v", err)
+	}
+
+	var resource *EnergyResource
+	if fetchedResource != nil {
+		resource = &EnergyResource{}
+		if err := json.Unmarshal(fetchedResource, resource); err != nil {
+			return fmt.Errorf("failed to unmarshal resource: %v", err)
+		}
+	}
+
+	if err := ac.checkLockAccess(ctx, resourceID, resource); err != nil {
+		return err
+	}
+
+	if resource != nil && resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is still active", resourceID)
+	}
+
+	return ac.releaseLock(ctx, resourceID)
+}
+
+// GetResourceLock returns the auction type holding the lock of a resource, or an empty string
+// when it is not locked. Lock peers call it through InvokeChaincode.
+func (ac *EnergyAuctionContract) GetResourceLock(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return "", err
+	}
+	if lock == nil {
+		return "", nil
+	}
+	return lock.AuctionType, nil
+}
+
+// SetLockPeers names the chaincodes on this channel whose resource locks must be free before
+// an auction starts here. An empty list stops consulting other chaincodes.
+func (ac *EnergyAuctionContract) SetLockPeers(ctx contractapi.TransactionContextInterface, chaincodeNames []string) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("client is not authorized: admin attribute required")
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		if chaincodeName == "" {
+			return fmt.Errorf("chaincode name must not be empty")
+		}
+	}
+
+	return ac.storeObject(ctx, ac.createCompositeKey(ctx, configObjectType, "lockPeers"), chaincodeNames)
+}
+
+// minIncrement is an absolute amount unless incrementIsPercent is set, in which case it is a
+// percentage of the current highest bid. closeMode is "hard" or "soft"; only soft-close
+// auctions use the extension window, as often as maxExtensions allows or without limit when it
+// is zero.
+func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration, extensionWindow int64, maxExtensions int, buyNowPrice, minDeposit, minIncrement float64, incrementIsPercent bool, allowedMSP, unit, closeMode string) error {
+	if err := ac.checkRole(ctx, producerRole); err != nil {
+		return err
+	}
+
+	if duration < minAuctionDuration || duration > maxAuctionDuration {
+		return fmt.Errorf("auction duration must be between %d and %d seconds", minAuctionDuration, maxAuctionDuration)
+	}
+
+	if extensionWindow < 0 {
+		return fmt.Errorf("extension window must not be negative")
+	}
+
+	if maxExtensions < 0 {
+		return fmt.Errorf("maximum number of extensions must not be negative")
+	}
+
+	if buyNowPrice < 0 {
+		return fmt.Errorf("buy-now price must not be negative")
+	}
+
+	if minDeposit < 0 {
+		return fmt.Errorf("minimum deposit must not be negative")
+	}
+
+	if minIncrement < 0 {
+		return fmt.Errorf("minimum increment must not be negative")
+	}
+
+	if incrementIsPercent && minIncrement > 100 {
+		return fmt.Errorf("minimum increment percentage must not exceed 100")
+	}
+
+	if !allowedUnits[unit] {
+		return fmt.Errorf("unit %q is not supported", unit)
+	}
+
+	if closeMode != closeModeHard && closeMode != closeModeSoft {
+		return fmt.Errorf("close mode must be %q or %q", closeModeHard, closeModeSoft)
+	}
+
+	if closeMode == closeModeHard && (extensionWindow > 0 || maxExtensions > 0) {
+		return fmt.Errorf("hard-close auctions cannot be extended")
+	}
+
 	resource, err := ac.fetchResource(ctx, resourceID)
 
 	if err != nil {
@@ -126,24 +484,46 @@ func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContext
 	}
 
 	if resource.AuctionStatus {
-		return fmt.Errorf("auction for resource with ID %s is already active", resourceID)
+		return ac.checkStaleAuction(ctx, resourceID)
 	}
 
 	if !resource.IsAvailable {
 		return fmt.Errorf("resource with ID %s is not available", resourceID)
 	}
 
-	currentTimeStamp, err := ctx.GetStub().GetTxTimestamp()
+	if err := ac.lockResource(ctx, resourceID, englishAuctionType); err != nil {
+		return err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
+	}
+
+	// Resources stored before delivery windows existed have none and are not limited by one.
+	if resource.DeliveryStart > 0 && currentTime+duration > resource.DeliveryStart {
+		return fmt.Errorf("auction for resource with ID %s must end before its delivery window starts", resourceID)
 	}
 
 	auction := EnergyAuction{
-		ResourceID:    resourceID,
-		Deadline:      currentTimeStamp.Seconds + duration,
-		HighestBid:    0,
-		HighestBidder: "",
-		IsActive:      true,
+		ResourceID:      resourceID,
+		Deadline:        currentTime + duration,
+		HighestBid:      0,
+		HighestBidder:   "",
+		ExtensionWindow: extensionWindow,
+		MaxExtensions:   maxExtensions,
+		BuyNowPrice:     ac.toMinorUnits(buyNowPrice),
+		MinDeposit:      ac.toMinorUnits(minDeposit),
+		AllowedMSP:      allowedMSP,
+		Unit:            unit,
+		CloseMode:       closeMode,
+		IsActive:        true,
+	}
+
+	if incrementIsPercent {
+		auction.IncrementPercent = minIncrement
+	} else {
+		auction.MinIncrement = ac.toMinorUnits(minIncrement)
 	}
 
 	resource.AuctionStatus = true
@@ -164,7 +544,15 @@ func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContext
 	}
 	updates[auctionKey] = auctionJSON
 
-	return ac.batchStore(ctx, updates)
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(AuctionStartedEvent{ResourceID: resourceID, Deadline: auction.Deadline, Unit: unit})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return ctx.GetStub().SetEvent("AuctionStarted", eventJSON)
 }
 
 func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
@@ -173,10 +561,38 @@ func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextIn
 		return "", err
 	}
 
-	return ac.marshalToString(auction)
+	view := AuctionView{
+		EnergyAuction:       *auction,
+		RemainingExtensions: auction.MaxExtensions - auction.ExtensionCount,
+		CloseRule:           "soft close: bids within the extension window push the deadline back",
+	}
+	if auction.CloseMode == closeModeHard {
+		view.CloseRule = "hard close: bids after the deadline are rejected"
+	} else if auction.MaxExtensions == 0 {
+		view.RemainingExtensions = -1
+	}
+
+	return ac.marshalToString(view)
+}
+
+// GetBidHistory returns every accepted bid of the auction in the order it was placed.
+func (ac *EnergyAuctionContract) GetBidHistory(ctx contractapi.TransactionContextInterface, resourceID string) ([]BidHistoryEntry, error) {
+	auction, err := ac.fetchAuction(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.BidHistory == nil {
+		return []BidHistoryEntry{}, nil
+	}
+	return auction.BidHistory, nil
 }
 
 func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount float64) error {
+	if err := ac.checkRole(ctx, consumerRole); err != nil {
+		return err
+	}
+
 	resource, err := ac.fetchResource(ctx, resourceID)
 	if err != nil {
 		return err
@@ -191,33 +607,229 @@ func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
 	}
 
-	currentTimeStamp, err := ctx.GetStub().GetTxTimestamp()
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	if auction.Deadline < currentTimeStamp.Seconds {
+	if auction.Deadline < currentTime {
+		if auction.CloseMode == closeModeHard {
+			return fmt.Errorf("auction for resource with ID %s closed at its hard deadline; bids are no longer accepted", resourceID)
+		}
 		return ac.EndAuction(ctx, resourceID)
 	}
 
-	if bidAmount <= resource.Price {
+	clientId, err := ctx.GetClientIdentity().GetID()
+
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientId == resource.Owner {
+		return fmt.Errorf("resource owner cannot bid on their own auction")
+	}
+
+	if err := ac.checkAllowedMSP(ctx, auction); err != nil {
+		return err
+	}
+
+	if err := ac.checkDeposit(ctx, resourceID, auction, clientId); err != nil {
+		return err
+	}
+
+	bidUnits := ac.toMinorUnits(bidAmount)
+
+	if bidUnits <= resource.Price {
 		return fmt.Errorf("bid amount must be higher than resource price")
 	}
 
-	if bidAmount <= auction.HighestBid {
-		return fmt.Errorf("bid amount must be higher than current highest bid")
+	if minimumBid := ac.minimumNextBid(auction); bidUnits < minimumBid {
+		return fmt.Errorf("bid amount must be at least %.2f", ac.fromMinorUnits(minimumBid))
+	}
+
+	auction.HighestBid = bidUnits
+	auction.HighestBidder = clientId
+	auction.BidHistory = append(auction.BidHistory, BidHistoryEntry{Bidder: clientId, Amount: bidUnits, Timestamp: currentTime})
+
+	// Extensions never push the deadline into the delivery window, and a zero MaxExtensions
+	// leaves them uncapped. Once a cap is reached, late bids are still accepted but leave the
+	// deadline unchanged. Only a bid that actually moves the deadline counts as an extension.
+	if auction.Deadline-currentTime <= auction.ExtensionWindow && (auction.MaxExtensions == 0 || auction.ExtensionCount < auction.MaxExtensions) {
+		extendedDeadline := auction.Deadline + auction.ExtensionWindow
+		if resource.DeliveryStart > 0 {
+			extendedDeadline = min(extendedDeadline, resource.DeliveryStart)
+		}
+		if extendedDeadline > auction.Deadline {
+			auction.Deadline = extendedDeadline
+			auction.ExtensionCount++
+		}
+	}
+
+	return ac.storeAuction(ctx, resourceID, *auction)
+}
+
+func (ac *EnergyAuctionContract) Deposit(ctx contractapi.TransactionContextInterface, resourceID string, amount float64) error {
+	if err := ac.checkRole(ctx, consumerRole); err != nil {
+		return err
+	}
+
+	if amount <= 0 {
+		return fmt.Errorf("deposit amount must be greater than zero")
+	}
+
+	auction, err := ac.fetchAuction(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
 	}
 
 	clientId, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	deposit, err := ac.fetchDeposit(ctx, resourceID, clientId)
+	if err != nil {
+		return err
+	}
+	if deposit == nil {
+		deposit = &EscrowDeposit{ResourceID: resourceID, Depositor: clientId}
+	}
+
+	deposit.Amount += ac.toMinorUnits(amount)
+
+	depositKey := ac.createCompositeKey(ctx, depositObjectType, resourceID, clientId)
+	return ac.storeObject(ctx, depositKey, *deposit)
+}
 
+func (ac *EnergyAuctionContract) RefundDeposit(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	auction, err := ac.fetchAuction(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if auction.IsActive {
+		return fmt.Errorf("auction for resource with ID %s has not yet closed", resourceID)
+	}
+
+	clientId, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
 		return fmt.Errorf("failed to get client ID: %v", err)
 	}
 
-	auction.HighestBid = bidAmount
+	deposit, err := ac.fetchDeposit(ctx, resourceID, clientId)
+	if err != nil {
+		return err
+	}
+	if deposit == nil {
+		return fmt.Errorf("no deposit found for client in auction for resource with ID %s", resourceID)
+	}
+
+	if deposit.Refunded {
+		return fmt.Errorf("deposit for auction for resource with ID %s has already been refunded", resourceID)
+	}
+
+	// The top bidder of an auction that ended unsold, e.g. after ForceEndAuction, won nothing.
+	if auction.Outcome == outcomeSold && auction.HighestBidder == clientId {
+		return fmt.Errorf("the winning bidder's deposit cannot be refunded")
+	}
+
+	deposit.Refunded = true
+
+	depositKey := ac.createCompositeKey(ctx, depositObjectType, resourceID, clientId)
+	return ac.storeObject(ctx, depositKey, *deposit)
+}
+
+func (ac *EnergyAuctionContract) BuyNow(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	if err := ac.checkRole(ctx, consumerRole); err != nil {
+		return err
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	auction, err := ac.fetchAuction(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
+	}
+
+	if auction.BuyNowPrice <= 0 {
+		return fmt.Errorf("auction for resource with ID %s has no buy-now price", resourceID)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline < currentTime {
+		return ac.EndAuction(ctx, resourceID)
+	}
+
+	clientId, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientId == resource.Owner {
+		return fmt.Errorf("resource owner cannot bid on their own auction")
+	}
+
+	if err := ac.checkAllowedMSP(ctx, auction); err != nil {
+		return err
+	}
+
+	if err := ac.checkDeposit(ctx, resourceID, auction, clientId); err != nil {
+		return err
+	}
+
+	auction.HighestBid = auction.BuyNowPrice
 	auction.HighestBidder = clientId
+	auction.BidHistory = append(auction.BidHistory, BidHistoryEntry{Bidder: clientId, Amount: auction.BuyNowPrice, Timestamp: currentTime})
+	auction.IsActive = false
+	auction.Outcome = outcomeSold
 
-	return ac.storeAuction(ctx, resourceID, *auction)
+	resource.AuctionStatus = false
+
+	if err := ac.releaseLock(ctx, resourceID); err != nil {
+		return err
+	}
+	resource.IsAvailable = false
+
+	updates := make(map[string][]byte)
+
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID)
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction: %v", err)
+	}
+	updates[auctionKey] = auctionJSON
+
+	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
+	resourceJSON, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %v", err)
+	}
+	updates[resourceKey] = resourceJSON
+
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(AuctionEndedEvent{ResourceID: resourceID, Winner: clientId, Price: auction.BuyNowPrice})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return ctx.GetStub().SetEvent("AuctionEnded", eventJSON)
 }
 
 func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextInterface, resourceID string) error {
@@ -230,18 +842,18 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
 	}
 
-	currentTimeStamp, err := ctx.GetStub().GetTxTimestamp()
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	if auction.Deadline > currentTimeStamp.Seconds {
+	if auction.Deadline > currentTime {
 		return fmt.Errorf("auction for resource with ID %s has not yet expired", resourceID)
 	}
 
 	winner := auction.HighestBidder
 	winningBid := auction.HighestBid
-	fmt.Printf("auction has been ended. Winner: %s with a bid of: %f\n", winner, winningBid)
+	fmt.Printf("auction has been ended. Winner: %s with a bid of: %.2f\n", winner, ac.fromMinorUnits(winningBid))
 
 	auction.IsActive = false
 
@@ -249,8 +861,84 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 
 	resource.AuctionStatus = false
 
+	if err := ac.releaseLock(ctx, resourceID); err != nil {
+		return err
+	}
+
 	if auction.HighestBidder != "" {
 		resource.IsAvailable = false
+		auction.Outcome = outcomeSold
+	} else {
+		auction.Outcome = outcomeUnsold
+	}
+
+	updates := make(map[string][]byte)
+
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID)
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction: %v", err)
+	}
+	updates[auctionKey] = auctionJSON
+
+	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
+	resourceJSON, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %v", err)
+	}
+	updates[resourceKey] = resourceJSON
+
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return err
+	}
+
+	if auction.Outcome == outcomeUnsold {
+		eventJSON, err := json.Marshal(AuctionUnsoldEvent{ResourceID: resourceID})
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %v", err)
+		}
+		return ctx.GetStub().SetEvent("AuctionUnsold", eventJSON)
+	}
+
+	eventJSON, err := json.Marshal(AuctionEndedEvent{ResourceID: resourceID, Winner: winner, Price: winningBid})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return ctx.GetStub().SetEvent("AuctionEnded", eventJSON)
+}
+
+// ForceEndAuction terminates an active auction before its deadline. No sale takes place, so the
+// resource becomes available again.
+func (ac *EnergyAuctionContract) ForceEndAuction(ctx contractapi.TransactionContextInterface, resourceID, reason string) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("client is not authorized: admin attribute required")
+	}
+
+	if reason == "" {
+		return fmt.Errorf("termination reason must not be empty")
+	}
+
+	auction, err := ac.fetchAuction(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	auction.IsActive = false
+	auction.TerminationReason = reason
+	auction.Outcome = outcomeUnsold
+	resource.AuctionStatus = false
+
+	if err := ac.releaseLock(ctx, resourceID); err != nil {
+		return err
 	}
 
 	updates := make(map[string][]byte)
@@ -269,10 +957,167 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 	}
 	updates[resourceKey] = resourceJSON
 
-	return ac.batchStore(ctx, updates)
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(AuctionForceEndedEvent{ResourceID: resourceID, Reason: reason})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return ctx.GetStub().SetEvent("AuctionForceEnded", eventJSON)
 }
 
 // Helper functions
+
+// currentTime is the transaction timestamp in seconds; a missing timestamp is an error.
+func (ac *EnergyAuctionContract) currentTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current block timestamp: %v", err)
+	}
+	if timestamp == nil {
+		return 0, fmt.Errorf("transaction timestamp is not set")
+	}
+	return timestamp.Seconds, nil
+}
+
+// checkStaleAuction explains why a resource still marked as in an auction cannot be
+// auctioned again. An expired auction must be ended first, since its outcome decides whether
+// the resource is still available; StartAuction cannot settle it in the same transaction.
+func (ac *EnergyAuctionContract) checkStaleAuction(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	auction, err := ac.fetchAuction(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.IsActive && auction.Deadline < currentTime {
+		return fmt.Errorf("previous auction for resource with ID %s expired without being ended; call EndAuction before starting a new one", resourceID)
+	}
+	return fmt.Errorf("auction for resource with ID %s is already active", resourceID)
+}
+
+// minimumNextBid returns the lowest bid, in minor units, that may replace the current highest
+// bid. Percentage increments are rounded up to the next minor unit.
+func (ac *EnergyAuctionContract) minimumNextBid(auction *EnergyAuction) int64 {
+	if auction.HighestBidder == "" {
+		return auction.HighestBid + 1
+	}
+
+	increment := auction.MinIncrement
+	if auction.IncrementPercent > 0 {
+		increment = int64(math.Ceil(float64(auction.HighestBid) * auction.IncrementPercent / 100))
+	}
+
+	return auction.HighestBid + max(increment, 1)
+}
+
+func (ac *EnergyAuctionContract) toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (ac *EnergyAuctionContract) fromMinorUnits(units int64) float64 {
+	return float64(units) / minorUnitsPerUnit
+}
+
+// lockResource takes the lock for auctionType unless this chaincode or one of its lock peers
+// already holds a lock on the resource. Taking a lock this chaincode already holds for the
+// same type succeeds.
+func (ac *EnergyAuctionContract) lockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		if lock.AuctionType != auctionType {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction", resourceID, lock.AuctionType)
+		}
+		return nil
+	}
+
+	if err := ac.checkPeerLocks(ctx, resourceID); err != nil {
+		return err
+	}
+
+	lockKey := ac.createCompositeKey(ctx, lockObjectType, resourceID)
+	return ac.storeObject(ctx, lockKey, ResourceLock{ResourceID: resourceID, AuctionType: auctionType})
+}
+
+func (ac *EnergyAuctionContract) releaseLock(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	if err := ctx.GetStub().DelState(ac.createCompositeKey(ctx, lockObjectType, resourceID)); err != nil {
+		return fmt.Errorf("failed to release resource lock: %v", err)
+	}
+	return nil
+}
+
+// checkPeerLocks fails if any lock peer holds a lock on the resource. Reads made through
+// InvokeChaincode on the same channel join this transaction's read set, so a peer lock taken
+// concurrently invalidates one of the two transactions.
+func (ac *EnergyAuctionContract) checkPeerLocks(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedPeers, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, configObjectType, "lockPeers"))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve lock peers: %v", err)
+	}
+	if fetchedPeers == nil {
+		return nil
+	}
+
+	var chaincodeNames []string
+	if err := json.Unmarshal(fetchedPeers, &chaincodeNames); err != nil {
+		return fmt.Errorf("failed to unmarshal lock peers: %v", err)
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		response := ctx.GetStub().InvokeChaincode(chaincodeName, [][]byte{[]byte("GetResourceLock"), []byte(resourceID)}, "")
+		if response.Status != shim.OK {
+			return fmt.Errorf("failed to query resource lock in chaincode %s: %s", chaincodeName, response.Message)
+		}
+		if auctionType := string(response.Payload); auctionType != "" {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction in chaincode %s", resourceID, auctionType, chaincodeName)
+		}
+	}
+	return nil
+}
+
+// checkLockAccess lets the resource owner and admins manage a resource lock. A lock whose
+// resource is no longer stored can only be managed by an admin.
+func (ac *EnergyAuctionContract) checkLockAccess(ctx contractapi.TransactionContextInterface, resourceID string, resource *EnergyResource) error {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if resource != nil && resource.Owner != "" && resource.Owner == clientID {
+		return nil
+	}
+
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("only the owner of resource with ID %s or an admin can manage its lock", resourceID)
+	}
+	return nil
+}
+
+func (ac *EnergyAuctionContract) fetchLock(ctx contractapi.TransactionContextInterface, resourceID string) (*ResourceLock, error) {
+	fetchedLock, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, lockObjectType, resourceID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resource lock: %v", err)
+	}
+	if fetchedLock == nil {
+		return nil, nil
+	}
+
+	var lock ResourceLock
+	if err := json.Unmarshal(fetchedLock, &lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource lock: %v", err)
+	}
+	return &lock, nil
+}
+
 func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error) {
 	jsonData, err := json.Marshal(v)
 	if err != nil {
@@ -281,6 +1126,49 @@ func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error)
 	return string(jsonData), nil
 }
 
+func (ac *EnergyAuctionContract) checkRole(ctx contractapi.TransactionContextInterface, role string) error {
+	clientRole, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to get client role: %v", err)
+	}
+	if !found || !strings.EqualFold(clientRole, role) {
+		return fmt.Errorf("client is not authorized: %s role required", role)
+	}
+	return nil
+}
+
+// checkAllowedMSP restricts an auction to a single organization when AllowedMSP is set.
+func (ac *EnergyAuctionContract) checkAllowedMSP(ctx contractapi.TransactionContextInterface, auction *EnergyAuction) error {
+	if auction.AllowedMSP == "" {
+		return nil
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != auction.AllowedMSP {
+		return fmt.Errorf("auction for resource with ID %s is restricted to members of %s", auction.ResourceID, auction.AllowedMSP)
+	}
+	return nil
+}
+
+// checkDeposit requires the client to have escrowed at least MinDeposit before bidding or buying.
+func (ac *EnergyAuctionContract) checkDeposit(ctx contractapi.TransactionContextInterface, resourceID string, auction *EnergyAuction, clientId string) error {
+	if auction.MinDeposit <= 0 {
+		return nil
+	}
+
+	deposit, err := ac.fetchDeposit(ctx, resourceID, clientId)
+	if err != nil {
+		return err
+	}
+	if deposit == nil || deposit.Amount < auction.MinDeposit {
+		return fmt.Errorf("a deposit of at least %.2f is required to bid", ac.fromMinorUnits(auction.MinDeposit))
+	}
+	return nil
+}
+
 func (ac *EnergyAuctionContract) checkResourceExists(ctx contractapi.TransactionContextInterface, resourceID string) error {
 	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
 
@@ -329,6 +1217,24 @@ func (ac *EnergyAuctionContract) fetchAuction(ctx contractapi.TransactionContext
 	return &auction, nil
 }
 
+func (ac *EnergyAuctionContract) fetchDeposit(ctx contractapi.TransactionContextInterface, resourceID string, depositor string) (*EscrowDeposit, error) {
+	depositKey := ac.createCompositeKey(ctx, depositObjectType, resourceID, depositor)
+
+	fetchedDeposit, err := ctx.GetStub().GetState(depositKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve deposit: %v", err)
+	}
+	if fetchedDeposit == nil {
+		return nil, nil
+	}
+
+	var deposit EscrowDeposit
+	if err := json.Unmarshal(fetchedDeposit, &deposit); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deposit: %v", err)
+	}
+	return &deposit, nil
+}
+
 func (ac *EnergyAuctionContract) storeObject(ctx contractapi.TransactionContextInterface, key string, object interface{}) error {
 	objectJSON, err := json.Marshal(object)
 	if err != nil {
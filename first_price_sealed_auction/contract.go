@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/khalidzahra/contractinfo"
+)
+
+type EnergyResource struct {
+	Volume        float64 `json:"volume"`
+	Price         int64   `json:"priceMinor"`
+	Type          string  `json:"type"`
+	IsAvailable   bool    `json:"isAvailable"`
+	AuctionStatus bool    `json:"auctionStatus"`
+}
+
+type EnergyAuction struct {
+	ResourceID     string            `json:"resourceID"`
+	Deadline       int64             `json:"deadline"`
+	RevealDeadline int64             `json:"revealDeadline"`
+	Bids           []Bid             `json:"bids"`
+	Commitments    map[string]string `json:"commitments"`
+	WinnerID       string            `json:"winnerID"`
+	WinnerPrice    int64             `json:"winnerPriceMinor"`
+	IsActive       bool              `json:"status"`
+}
+
+type Bid struct {
+	BidID      string `json:"bidID"`
+	ResourceID string `json:"resourceID"`
+	Bidder     string `json:"bidder"`
+	BidPrice   int64  `json:"bidPriceMinor"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// Sealed bids and the resource price are held in cents.
+const minorUnitsPerUnit = 100
+
+const contractVersion = "1.0.0"
+
+type EnergyAuctionContract struct {
+	contractapi.Contract
+}
+
+// GetContractInfo reports the sealed first-price auction, where revealed bids are paid in full.
+func (ac *EnergyAuctionContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*contractinfo.ContractInfo, error) {
+	return &contractinfo.ContractInfo{
+		AuctionType:     "first-price-sealed",
+		Version:         contractVersion,
+		SupportsReserve: false,
+		SupportsSealed:  true,
+	}, nil
+}
+
+func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType string) error {
+	if energyVolume <= 0 {
+		return fmt.Errorf("energy volume must be greater than zero")
+	}
+
+	if energyPrice < 0 {
+		return fmt.Errorf("energy price must not be negative")
+	}
+
+	if resourceType == "" {
+		return fmt.Errorf("resource type must not be empty")
+	}
+
+	if err := ac.checkResourceExists(ctx, resourceID); err != nil {
+		return err
+	}
+
+	resource := EnergyResource{
+		Volume:        energyVolume,
+		Price:         ac.toMinorUnits(energyPrice),
+		Type:          resourceType,
+		IsAvailable:   true,
+		AuctionStatus: false,
+	}
+
+	return ac.storeObject(ctx, resourceID, resource)
+}
+
+func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return "", err
+	}
+	return ac.marshalToString(resource)
+}
+
+func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration, revealDuration int64) error {
+	if revealDuration <= 0 {
+		return fmt.Errorf("reveal duration must be greater than zero")
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is already active", resourceID)
+	}
+
+	if !resource.IsAvailable {
+		return fmt.Errorf("resource with ID %s is not available", resourceID)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	auction := EnergyAuction{
+		ResourceID:     resourceID,
+		Deadline:       currentTime + duration,
+		RevealDeadline: currentTime + duration + revealDuration,
+		Bids:           []Bid{},
+		Commitments:    map[string]string{},
+		IsActive:       true,
+	}
+	resource.AuctionStatus = true
+
+	if err := ac.storeObject(ctx, resourceID, *resource); err != nil {
+		return err
+	}
+
+	return ac.storeObject(ctx, "auction:"+resourceID, auction)
+}
+
+func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextInterface, resourceID string) (*EnergyAuction, error) {
+	auction, err := ac.fetchAuction(ctx, "auction:"+resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revealed amounts stay hidden until the auction has been ended.
+	if auction.IsActive {
+		auction.Bids = []Bid{}
+	}
+
+	return auction, nil
+}
+
+func (ac *EnergyAuctionContract) CommitBid(ctx contractapi.TransactionContextInterface, resourceID string, hashHex string) error {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
+	}
+
+	if _, err := hex.DecodeString(hashHex); err != nil || len(hashHex) != sha256.Size*2 {
+		return fmt.Errorf("commitment must be a hex encoded SHA-256 hash")
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline < currentTime {
+		return fmt.Errorf("commit phase for auction with ID %s has ended", auctionID)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if auction.Commitments == nil {
+		auction.Commitments = map[string]string{}
+	}
+	auction.Commitments[clientID] = strings.ToLower(hashHex)
+
+	return ac.storeObject(ctx, auctionID, *auction)
+}
+
+// The commitment is the SHA-256 hash of "<clientID>:<resourceID>:<bidAmount>:<nonce>", where the
+// amount is formatted without trailing zeros (e.g. "20" or "20.5"). Binding the bidder and
+// resource stops a copied commitment from being revealed by anyone else or in another auction.
+func (ac *EnergyAuctionContract) RevealBid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount float64, nonce string) error {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline >= currentTime {
+		return fmt.Errorf("reveal phase for auction with ID %s has not yet started", auctionID)
+	}
+
+	if auction.RevealDeadline < currentTime {
+		return fmt.Errorf("reveal phase for auction with ID %s has ended", auctionID)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	commitment, ok := auction.Commitments[clientID]
+	if !ok {
+		return fmt.Errorf("no bid commitment found for client in auction with ID %s", auctionID)
+	}
+
+	preimage := clientID + ":" + resourceID + ":" + strconv.FormatFloat(bidAmount, 'f', -1, 64) + ":" + nonce
+	hash := sha256.Sum256([]byte(preimage))
+	if hex.EncodeToString(hash[:]) != commitment {
+		return fmt.Errorf("revealed bid does not match commitment")
+	}
+
+	if ac.toMinorUnits(bidAmount) <= resource.Price {
+		return fmt.Errorf("bid amount must be higher than resource price")
+	}
+
+	bid := Bid{
+		BidID:      fmt.Sprintf("%s:%s:%d", auctionID, clientID, currentTime),
+		ResourceID: resourceID,
+		Bidder:     clientID,
+		BidPrice:   ac.toMinorUnits(bidAmount),
+		Timestamp:  currentTime,
+	}
+
+	delete(auction.Commitments, clientID)
+	auction.Bids = append(auction.Bids, bid)
+
+	return ac.storeObject(ctx, auctionID, *auction)
+}
+
+func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.RevealDeadline > currentTime {
+		return fmt.Errorf("reveal phase for auction with ID %s has not yet ended", auctionID)
+	}
+
+	// Commitments that were never revealed are not bids and take no part in the outcome.
+	sort.Slice(auction.Bids, func(i, j int) bool {
+		if auction.Bids[i].BidPrice != auction.Bids[j].BidPrice {
+			return auction.Bids[i].BidPrice > auction.Bids[j].BidPrice
+		}
+		if auction.Bids[i].Timestamp != auction.Bids[j].Timestamp {
+			return auction.Bids[i].Timestamp < auction.Bids[j].Timestamp
+		}
+		return auction.Bids[i].BidID < auction.Bids[j].BidID
+	})
+
+	auction.IsActive = false
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	resource.AuctionStatus = false
+
+	if len(auction.Bids) > 0 {
+		resource.IsAvailable = false
+		// First-price rule: the winner pays exactly what they bid, never the runner-up's price.
+		auction.WinnerID = auction.Bids[0].Bidder
+		auction.WinnerPrice = auction.Bids[0].BidPrice
+	}
+
+	if err := ac.storeObject(ctx, resourceID, *resource); err != nil {
+		return err
+	}
+
+	return ac.storeObject(ctx, auctionID, *auction)
+}
+
+// Helper functions
+
+// currentTime returns the proposal timestamp in Unix seconds and rejects a nil one.
+func (ac *EnergyAuctionContract) currentTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current block timestamp: %v", err)
+	}
+	if timestamp == nil {
+		return 0, fmt.Errorf("transaction timestamp is not set")
+	}
+	return timestamp.Seconds, nil
+}
+
+func (ac *EnergyAuctionContract) toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal: %v", err)
+	}
+	return string(jsonData), nil
+}
+
+func (ac *EnergyAuctionContract) checkResourceExists(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedResource, err := ctx.GetStub().GetState(resourceID)
+	if err != nil {
+		return fmt.Errorf("failed to interact with world state: %v", err)
+	}
+	if fetchedResource != nil {
+		return fmt.Errorf("a resource already exists with ID: %s", resourceID)
+	}
+	return nil
+}
+
+func (ac *EnergyAuctionContract) fetchResource(ctx contractapi.TransactionContextInterface, resourceID string) (*EnergyResource, error) {
+	fetchedResource, err := ctx.GetStub().GetState(resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resource: %v", err)
+	}
+	if fetchedResource == nil {
+		return nil, fmt.Errorf("resource with ID %s does not exist", resourceID)
+	}
+
+	var resource EnergyResource
+	if err := json.Unmarshal(fetchedResource, &resource); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource: %v", err)
+	}
+	return &resource, nil
+}
+
+func (ac *EnergyAuctionContract) fetchAuction(ctx contractapi.TransactionContextInterface, auctionID string) (*EnergyAuction, error) {
+	fetchedAuction, err := ctx.GetStub().GetState(auctionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auction: %v", err)
+	}
+	if fetchedAuction == nil {
+		return nil, fmt.Errorf("auction with ID %s does not exist", auctionID)
+	}
+
+	var auction EnergyAuction
+	if err := json.Unmarshal(fetchedAuction, &auction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auction: %v", err)
+	}
+	return &auction, nil
+}
+
+func (ac *EnergyAuctionContract) storeObject(ctx contractapi.TransactionContextInterface, key string, object interface{}) error {
+	objectJSON, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object: %v", err)
+	}
+	return ctx.GetStub().PutState(key, objectJSON)
+}
+
+func main() {
+	chaincode, err := contractapi.NewChaincode(&EnergyAuctionContract{})
+	if err != nil {
+		log.Panicf("Error creating asset chaincode: %v", err)
+	}
+
+	if err := chaincode.Start(); err != nil {
+		log.Panicf("Error starting asset chaincode: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type testIdentity struct {
+	id string
+}
+
+func (ti testIdentity) GetID() (string, error) {
+	return ti.id, nil
+}
+
+func (ti testIdentity) GetMSPID() (string, error) {
+	return "Org1MSP", nil
+}
+
+func (ti testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (ti testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	return nil
+}
+
+func (ti testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{}, nil
+}
+
+func newTestContext(stub *shimtest.MockStub, clientID string) *contractapi.TransactionContext {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(testIdentity{id: clientID})
+	return ctx
+}
+
+// startTransaction begins a new mock transaction at the given Unix time.
+func startTransaction(stub *shimtest.MockStub, txID string, seconds int64) {
+	stub.MockTransactionStart(txID)
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: seconds}
+}
+
+func commitment(clientID, resourceID, amount, nonce string) string {
+	hash := sha256.Sum256([]byte(clientID + ":" + resourceID + ":" + amount + ":" + nonce))
+	return hex.EncodeToString(hash[:])
+}
+
+// startTestAuction lists res1 at 10 and opens its auction at time 1000. Commitments are taken
+// until 1100 and revealed until 1200.
+func startTestAuction(t *testing.T) (*EnergyAuctionContract, *shimtest.MockStub) {
+	t.Helper()
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("first_price_sealed_auction", nil)
+	seller := newTestContext(stub, "producer")
+
+	startTransaction(stub, "tx-start", 1000)
+	if err := ac.SubmitEnergyResource(seller, "res1", 10, 10, "solar"); err != nil {
+		t.Fatalf("SubmitEnergyResource failed: %v", err)
+	}
+	if err := ac.StartAuction(seller, "res1", 100, 100); err != nil {
+		t.Fatalf("StartAuction failed: %v", err)
+	}
+	stub.MockTransactionEnd("tx-start")
+
+	return ac, stub
+}
+
+func commitBid(t *testing.T, ac *EnergyAuctionContract, stub *shimtest.MockStub, clientID, resourceID, hashHex string) {
+	t.Helper()
+	startTransaction(stub, "tx-commit-"+clientID, 1050)
+	defer stub.MockTransactionEnd("tx-commit-" + clientID)
+	if err := ac.CommitBid(newTestContext(stub, clientID), resourceID, hashHex); err != nil {
+		t.Fatalf("CommitBid(%s) failed: %v", clientID, err)
+	}
+}
+
+func revealBid(ac *EnergyAuctionContract, stub *shimtest.MockStub, clientID string, at int64, amount float64, nonce string) error {
+	startTransaction(stub, "tx-reveal-"+clientID, at)
+	defer stub.MockTransactionEnd("tx-reveal-" + clientID)
+	return ac.RevealBid(newTestContext(stub, clientID), "res1", amount, nonce)
+}
+
+func endTestAuction(t *testing.T, ac *EnergyAuctionContract, stub *shimtest.MockStub) *EnergyAuction {
+	t.Helper()
+	startTransaction(stub, "tx-end", 1300)
+	defer stub.MockTransactionEnd("tx-end")
+	if err := ac.EndAuction(newTestContext(stub, "producer"), "res1"); err != nil {
+		t.Fatalf("EndAuction failed: %v", err)
+	}
+	auction, err := ac.GetAuction(newTestContext(stub, "producer"), "res1")
+	if err != nil {
+		t.Fatalf("GetAuction failed: %v", err)
+	}
+	return auction
+}
+
+func TestRevealBidOnlyDuringRevealPhase(t *testing.T) {
+	ac, stub := startTestAuction(t)
+	commitBid(t, ac, stub, "alice", "res1", commitment("alice", "res1", "20", "n1"))
+
+	if err := revealBid(ac, stub, "alice", 1090, 20, "n1"); err == nil || !strings.Contains(err.Error(), "has not yet started") {
+		t.Errorf("expected a reveal before the deadline to be rejected, got %v", err)
+	}
+	if err := revealBid(ac, stub, "alice", 1250, 20, "n1"); err == nil || !strings.Contains(err.Error(), "has ended") {
+		t.Errorf("expected a reveal after the reveal deadline to be rejected, got %v", err)
+	}
+	if err := revealBid(ac, stub, "alice", 1150, 20, "n1"); err != nil {
+		t.Errorf("expected a reveal during the reveal phase to succeed, got %v", err)
+	}
+}
+
+func TestRevealBidRejectsCommitmentOfAnotherBidderOrResource(t *testing.T) {
+	ac, stub := startTestAuction(t)
+
+	// bob copies alice's commitment, and carol commits a hash made for another resource.
+	aliceCommitment := commitment("alice", "res1", "20", "n1")
+	commitBid(t, ac, stub, "alice", "res1", aliceCommitment)
+	commitBid(t, ac, stub, "bob", "res1", aliceCommitment)
+	commitBid(t, ac, stub, "carol", "res1", commitment("carol", "res2", "25", "n3"))
+
+	if err := revealBid(ac, stub, "bob", 1150, 20, "n1"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected bob's reveal of a copied commitment to be rejected, got %v", err)
+	}
+	if err := revealBid(ac, stub, "carol", 1150, 25, "n3"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected carol's reveal of a commitment for res2 to be rejected, got %v", err)
+	}
+	if err := revealBid(ac, stub, "alice", 1150, 20, "n1"); err != nil {
+		t.Errorf("expected alice's own reveal to succeed, got %v", err)
+	}
+}
+
+func TestEndAuctionIgnoresUnrevealedCommitments(t *testing.T) {
+	ac, stub := startTestAuction(t)
+	commitBid(t, ac, stub, "alice", "res1", commitment("alice", "res1", "30", "n1"))
+	commitBid(t, ac, stub, "bob", "res1", commitment("bob", "res1", "20", "n2"))
+
+	if err := revealBid(ac, stub, "bob", 1150, 20, "n2"); err != nil {
+		t.Fatalf("RevealBid(bob) failed: %v", err)
+	}
+
+	auction := endTestAuction(t, ac, stub)
+	if auction.WinnerID != "bob" || auction.WinnerPrice != 2000 {
+		t.Errorf("expected bob to win at 2000 while alice never revealed, got %s at %d", auction.WinnerID, auction.WinnerPrice)
+	}
+	if len(auction.Bids) != 1 {
+		t.Errorf("expected only the revealed bid, got %+v", auction.Bids)
+	}
+}
+
+func TestWinnerPaysOwnBid(t *testing.T) {
+	ac, stub := startTestAuction(t)
+	commitBid(t, ac, stub, "alice", "res1", commitment("alice", "res1", "30.5", "n1"))
+	commitBid(t, ac, stub, "bob", "res1", commitment("bob", "res1", "20", "n2"))
+
+	if err := revealBid(ac, stub, "alice", 1150, 30.5, "n1"); err != nil {
+		t.Fatalf("RevealBid(alice) failed: %v", err)
+	}
+	if err := revealBid(ac, stub, "bob", 1150, 20, "n2"); err != nil {
+		t.Fatalf("RevealBid(bob) failed: %v", err)
+	}
+
+	auction := endTestAuction(t, ac, stub)
+	if auction.WinnerID != "alice" {
+		t.Fatalf("expected alice to win, got %s", auction.WinnerID)
+	}
+	if auction.WinnerPrice != 3050 {
+		t.Errorf("expected alice to pay her own bid of 3050 rather than the runner-up's 2000, got %d", auction.WinnerPrice)
+	}
+}
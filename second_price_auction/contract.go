@@ -1,150 +1,1205 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/khalidzahra/contractinfo"
 )
 
 type EnergyResource struct {
-	Volume        float64 `json:"volume"`
-	Price         float64 `json:"price"`
-	Type          string  `json:"type"`
-	IsAvailable   bool    `json:"isAvailable"`
-	AuctionStatus bool    `json:"auctionStatus"`
+	Volume          float64 `json:"volume"`
+	RemainingVolume float64 `json:"remainingVolume"`
+	Price           int64   `json:"priceMinor"`
+	Type            string  `json:"type"`
+	PricingMode     string  `json:"pricingMode"`
+	IsAvailable     bool    `json:"isAvailable"`
+	AuctionStatus   bool    `json:"auctionStatus"`
+	Owner           string  `json:"owner"`
 }
 
 type EnergyAuction struct {
-	ResourceID  string  `json:"resourceID"`
-	Deadline    int64   `json:"deadline"`
-	Bids        []Bid   `json:"bids"`
-	WinnerID    string  `json:"winnerID"`
-	WinnerPrice float64 `json:"winnerPrice"`
-	IsActive    bool    `json:"status"`
+	ResourceID        string            `json:"resourceID"`
+	Deadline          int64             `json:"deadline"`
+	RevealDeadline    int64             `json:"revealDeadline"`
+	TieBreak          string            `json:"tieBreak"`
+	Bids              []Bid             `json:"bids"`
+	Commitments       map[string]string `json:"commitments"`
+	PrivateBidHashes  map[string]string `json:"privateBidHashes"`
+	ProcessedBidRefs  map[string]bool   `json:"processedBidRefs"`
+	WinnerID          string            `json:"winnerID"`
+	WinnerPrice       int64             `json:"winnerPriceMinor"`
+	Winners           []Allocation      `json:"winners"`
+	LosingBidders     []string          `json:"losingBidders"`
+	UnallocatedVolume float64           `json:"unallocatedVolume"`
+	IsActive          bool              `json:"status"`
 }
 
 type Bid struct {
-	BidID      string  `json:"bidID"`
-	ResourceID string  `json:"resourceID"`
-	Bidder     string  `json:"bidder"`
-	BidPrice   float64 `json:"bidPrice"`
-	Timestamp  int64   `json:"timestamp"`
+	BidID           string  `json:"bidID"`
+	ResourceID      string  `json:"resourceID"`
+	Bidder          string  `json:"bidder"`
+	BidPrice        int64   `json:"bidPriceMinor"`
+	RequestedVolume float64 `json:"requestedVolume"`
+	Timestamp       int64   `json:"timestamp"`
+	ValidUntil      int64   `json:"validUntil"`
 }
 
+type Allocation struct {
+	Bidder          string  `json:"bidder"`
+	AllocatedVolume float64 `json:"allocatedVolume"`
+	ClearingPrice   int64   `json:"clearingPriceMinor"`
+}
+
+type AuctionView struct {
+	ResourceID        string       `json:"resourceID"`
+	Deadline          int64        `json:"deadline"`
+	RevealDeadline    int64        `json:"revealDeadline"`
+	TieBreak          string       `json:"tieBreak"`
+	Bids              []Bid        `json:"bids"`
+	BidCount          int          `json:"bidCount"`
+	BidsHidden        bool         `json:"bidsHidden"`
+	WinnerID          string       `json:"winnerID"`
+	WinnerPrice       int64        `json:"winnerPriceMinor"`
+	Winners           []Allocation `json:"winners"`
+	LosingBidders     []string     `json:"losingBidders"`
+	UnallocatedVolume float64      `json:"unallocatedVolume"`
+	IsActive          bool         `json:"status"`
+}
+
+type AuctionWinner struct {
+	ResourceID  string `json:"resourceID"`
+	WinnerID    string `json:"winnerID"`
+	WinnerPrice int64  `json:"winnerPriceMinor"`
+}
+
+type WinnerExplanation struct {
+	ResourceID      string  `json:"resourceID"`
+	WinnerID        string  `json:"winnerID"`
+	WinnerPrice     int64   `json:"winnerPriceMinor"`
+	TopBids         []int64 `json:"topBidsMinor"`
+	PriceSetByBidID string  `json:"priceSetByBidID"`
+	PrivateBidCount int     `json:"privateBidCount"`
+}
+
+type WinnerRefund struct {
+	ResourceID  string `json:"resourceID"`
+	WinnerID    string `json:"winnerID"`
+	WinningBid  int64  `json:"winningBidMinor"`
+	WinnerPrice int64  `json:"winnerPriceMinor"`
+	Refund      int64  `json:"refundMinor"`
+}
+
+type BidderSummary struct {
+	Bidder     string `json:"bidder"`
+	HighestBid int64  `json:"highestBidMinor"`
+	BidCount   int    `json:"bidCount"`
+}
+
+type BidderRank struct {
+	ResourceID   string `json:"resourceID"`
+	Rank         int    `json:"rank"`
+	TotalBidders int    `json:"totalBidders"`
+	Participated bool   `json:"participated"`
+}
+
+type ParticipatedAuction struct {
+	ResourceID string `json:"resourceID"`
+	IsActive   bool   `json:"status"`
+	IsTopBid   bool   `json:"isTopBid"`
+	RankHidden bool   `json:"rankHidden"`
+}
+
+type BidderPosition struct {
+	ResourceID    string `json:"resourceID"`
+	Bid           Bid    `json:"bid"`
+	AuctionActive bool   `json:"auctionActive"`
+}
+
+type AuctionHistoryRecord struct {
+	TxID      string       `json:"txID"`
+	Timestamp string       `json:"timestamp"`
+	IsDelete  bool         `json:"isDelete"`
+	Value     *AuctionView `json:"value"`
+}
+
+type PrivateBidInput struct {
+	BidAmount       float64 `json:"bidAmount"`
+	RequestedVolume float64 `json:"requestedVolume"`
+	ValidUntil      int64   `json:"validUntil"`
+	Salt            string  `json:"salt"`
+}
+
+// PrivateBidRecord is what PrivateBid stores in the collection. The salt keeps the public hash
+// from being matched by hashing guessed bids.
+type PrivateBidRecord struct {
+	Bid  Bid    `json:"bid"`
+	Salt string `json:"salt"`
+}
+
+type MeritOrderPage struct {
+	Resources []EnergyResource `json:"resources"`
+	Bookmark  string           `json:"bookmark"`
+}
+
+// Private bid amounts are kept in this collection; only their hashes reach the public ledger.
+const privateBidCollection = "bidsCollection"
+
+// Private bids must carry a random salt of at least this many characters, e.g. 16 random bytes
+// hex encoded.
+const minPrivateBidSaltLength = 32
+
+const (
+	tieBreakEarliest = "earliest"
+	tieBreakLatest   = "latest"
+)
+
+// ResourceLock records which auction type holds a resource until its auction ends.
+type ResourceLock struct {
+	ResourceID  string `json:"resourceID"`
+	AuctionType string `json:"auctionType"`
+}
+
+const (
+	lockKeyPrefix          = "lock:"
+	lockPeersKey           = "config:lockPeers"
+	englishAuctionType     = "english"
+	secondPriceAuctionType = "second-price"
+)
+
+// Clients carrying this attribute set to "true" may manage the lock of any resource and choose
+// the lock peers.
+const adminAttribute = "admin"
+
+// Under per-unit pricing a bid is compared to the resource price directly; under total pricing
+// a bid must request the whole remaining volume and exceed price times that volume, so every
+// bid is for the same volume and totals clear like per-unit prices.
+const (
+	pricingModePerUnit = "per-unit"
+	pricingModeTotal   = "total"
+)
+
+// A bid may be retracted for this many seconds after it is placed; after that it is binding.
+const retractWindowSeconds = 300
+
+// Bid and clearing prices are held in cents; amounts passed in major units are converted on entry.
+const minorUnitsPerUnit = 100
+
+// Resources, auctions and bids stored before the move to cents carry major-unit floats under
+// their old field names. They are converted as they are decoded.
+func legacyMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (r *EnergyResource) UnmarshalJSON(data []byte) error {
+	type stored EnergyResource
+	legacy := struct {
+		*stored
+		LegacyPrice *float64 `json:"price"`
+	}{stored: (*stored)(r)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyPrice != nil {
+		r.Price = legacyMinorUnits(*legacy.LegacyPrice)
+	}
+	return nil
+}
+
+func (a *EnergyAuction) UnmarshalJSON(data []byte) error {
+	type stored EnergyAuction
+	legacy := struct {
+		*stored
+		LegacyWinnerPrice *float64 `json:"winnerPrice"`
+	}{stored: (*stored)(a)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyWinnerPrice != nil {
+		a.WinnerPrice = legacyMinorUnits(*legacy.LegacyWinnerPrice)
+	}
+	return nil
+}
+
+func (b *Bid) UnmarshalJSON(data []byte) error {
+	type stored Bid
+	legacy := struct {
+		*stored
+		LegacyBidPrice *float64 `json:"bidPrice"`
+	}{stored: (*stored)(b)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyBidPrice != nil {
+		b.BidPrice = legacyMinorUnits(*legacy.LegacyBidPrice)
+	}
+	return nil
+}
+
+const contractVersion = "1.2.0"
+
 type EnergyAuctionContract struct {
 	contractapi.Contract
 }
 
-func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType string) error {
+// GetContractInfo reports the second-price auction, which accepts sealed commitments and
+// private bids but has no reserve.
+func (ac *EnergyAuctionContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*contractinfo.ContractInfo, error) {
+	return &contractinfo.ContractInfo{
+		AuctionType:     secondPriceAuctionType,
+		Version:         contractVersion,
+		SupportsReserve: false,
+		SupportsSealed:  true,
+	}, nil
+}
+
+func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType, pricingMode string) error {
+	if energyVolume <= 0 {
+		return fmt.Errorf("energy volume must be greater than zero")
+	}
+
+	if energyPrice < 0 {
+		return fmt.Errorf("energy price must not be negative")
+	}
+
+	if resourceType == "" {
+		return fmt.Errorf("resource type must not be empty")
+	}
+
+	if pricingMode == "" {
+		pricingMode = pricingModePerUnit
+	}
+
+	if pricingMode != pricingModePerUnit && pricingMode != pricingModeTotal {
+		return fmt.Errorf("pricing mode must be %q or %q", pricingModePerUnit, pricingModeTotal)
+	}
+
 	if err := ac.checkResourceExists(ctx, resourceID); err != nil {
 		return err
 	}
 
-	resource := EnergyResource{
-		Volume:        energyVolume,
-		Price:         energyPrice,
-		Type:          resourceType,
-		IsAvailable:   true,
-		AuctionStatus: false,
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	resource := EnergyResource{
+		Volume:          energyVolume,
+		RemainingVolume: energyVolume,
+		Price:           ac.toMinorUnits(energyPrice),
+		Type:            resourceType,
+		PricingMode:     pricingMode,
+		IsAvailable:     true,
+		AuctionStatus:   false,
+		Owner:           clientID,
+	}
+
+	return ac.storeObject(ctx, resourceID, resource)
+}
+
+func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return "", err
+	}
+	return ac.marshalToString(resource)
+}
+
+func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContextInterface) ([]EnergyResource, error) {
+	results, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var resources []EnergyResource
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ac.isResourceKey(next.Key) {
+			continue
+		}
+
+		var resource EnergyResource
+		err = json.Unmarshal(next.Value, &resource)
+		if err != nil { // Not an EnergyResource object
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return resources, nil
+}
+
+// GetMeritOrderPaginated returns one page of resources sorted by price. Sorting only
+// covers the current page, so callers that need a global merit order must merge pages
+// themselves. Auction keys are skipped, so a page may hold fewer than pageSize resources.
+func (ac *EnergyAuctionContract) GetMeritOrderPaginated(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*MeritOrderPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be greater than zero")
+	}
+
+	results, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	resources := []EnergyResource{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ac.isResourceKey(next.Key) {
+			continue
+		}
+
+		var resource EnergyResource
+		if err := json.Unmarshal(next.Value, &resource); err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return &MeritOrderPage{
+		Resources: resources,
+		Bookmark:  metadata.Bookmark,
+	}, nil
+}
+
+// LockResource reserves a resource for an auction type. Only the resource owner or an admin
+// may take the lock.
+func (ac *EnergyAuctionContract) LockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	if auctionType != englishAuctionType && auctionType != secondPriceAuctionType {
+		return fmt.Errorf("auction type must be %q or %q", englishAuctionType, secondPriceAuctionType)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "manage its lock"); err != nil {
+		return err
+	}
+
+	return ac.lockResource(ctx, resourceID, auctionType)
+}
+
+// UnlockResource releases the lock of a resource that is not in an auction. Only the resource
+// owner or an admin may release it; a lock left behind by a deleted resource needs an admin.
+func (ac *EnergyAuctionContract) UnlockResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedResource, err := ctx.GetStub().GetState(resourceID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve resource: %v", err)
+	}
+
+	var resource *EnergyResource
+	if fetchedResource != nil {
+		resource = &EnergyResource{}
+		if err := json.Unmarshal(fetchedResource, resource); err != nil {
+			return fmt.Errorf("failed to unmarshal resource: %v", err)
+		}
+	}
+
+	if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "manage its lock"); err != nil {
+		return err
+	}
+
+	if resource != nil && resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is still active", resourceID)
+	}
+
+	return ctx.GetStub().DelState(lockKeyPrefix + resourceID)
+}
+
+// GetResourceLock returns the auction type holding the lock of a resource, or an empty string
+// when it is not locked. Lock peers call it through InvokeChaincode.
+func (ac *EnergyAuctionContract) GetResourceLock(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return "", err
+	}
+	if lock == nil {
+		return "", nil
+	}
+	return lock.AuctionType, nil
+}
+
+// SetLockPeers names the chaincodes on this channel whose resource locks must be free before
+// an auction starts here. An empty list stops consulting other chaincodes.
+func (ac *EnergyAuctionContract) SetLockPeers(ctx contractapi.TransactionContextInterface, chaincodeNames []string) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("client is not authorized: admin attribute required")
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		if chaincodeName == "" {
+			return fmt.Errorf("chaincode name must not be empty")
+		}
+	}
+
+	return ac.storeObject(ctx, lockPeersKey, chaincodeNames)
+}
+
+// RelistResource makes a partially allocated resource available again so its remaining volume
+// can go to a new auction. Only the resource owner or an admin may relist it.
+func (ac *EnergyAuctionContract) RelistResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "relist it"); err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is still active", resourceID)
+	}
+
+	if resource.RemainingVolume <= 0 {
+		return fmt.Errorf("resource with ID %s is fully allocated", resourceID)
+	}
+
+	resource.IsAvailable = true
+
+	return ac.storeObject(ctx, resourceID, *resource)
+}
+
+func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration, revealDuration int64, tieBreak string) error {
+	if revealDuration < 0 {
+		return fmt.Errorf("reveal duration must not be negative")
+	}
+
+	if tieBreak != tieBreakEarliest && tieBreak != tieBreakLatest {
+		return fmt.Errorf("tie-break strategy must be %q or %q", tieBreakEarliest, tieBreakLatest)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is already active", resourceID)
+	}
+
+	if resource.RemainingVolume <= 0 {
+		return fmt.Errorf("resource with ID %s is fully allocated", resourceID)
+	}
+
+	if !resource.IsAvailable {
+		return fmt.Errorf("resource with ID %s is not available", resourceID)
+	}
+
+	if err := ac.lockResource(ctx, resourceID, secondPriceAuctionType); err != nil {
+		return err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	auction := EnergyAuction{
+		ResourceID:     resourceID,
+		Deadline:       currentTime + duration,
+		RevealDeadline: currentTime + duration + revealDuration,
+		TieBreak:       tieBreak,
+		Bids:           []Bid{},
+		Commitments:    map[string]string{},
+		IsActive:       true,
+	}
+	resource.AuctionStatus = true
+
+	if err := ac.storeObject(ctx, resourceID, *resource); err != nil {
+		return err
+	}
+
+	return ac.storeObject(ctx, "auction:"+resourceID, auction)
+}
+
+func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextInterface, resourceID string) (*AuctionView, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ac.auctionView(auction), nil
+}
+
+// auctionView hides the bids of an auction that is still running.
+func (ac *EnergyAuctionContract) auctionView(auction *EnergyAuction) *AuctionView {
+	view := AuctionView{
+		ResourceID:        auction.ResourceID,
+		Deadline:          auction.Deadline,
+		RevealDeadline:    auction.RevealDeadline,
+		TieBreak:          auction.TieBreak,
+		Bids:              auction.Bids,
+		BidCount:          len(auction.Bids) + len(auction.PrivateBidHashes),
+		WinnerID:          auction.WinnerID,
+		WinnerPrice:       auction.WinnerPrice,
+		Winners:           auction.Winners,
+		LosingBidders:     auction.LosingBidders,
+		UnallocatedVolume: auction.UnallocatedVolume,
+		IsActive:          auction.IsActive,
+	}
+
+	if auction.IsActive {
+		view.Bids = []Bid{}
+		view.BidsHidden = true
+	}
+
+	return &view
+}
+
+func (ac *EnergyAuctionContract) GetBid(ctx contractapi.TransactionContextInterface, resourceID, bidID string) (*Bid, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	for _, bid := range auction.Bids {
+		if bid.BidID != bidID {
+			continue
+		}
+
+		// While bids are sealed only the bidder may look up their own bid.
+		if auction.IsActive && bid.Bidder != clientID {
+			break
+		}
+		return &bid, nil
+	}
+
+	return nil, fmt.Errorf("bid with ID %s does not exist in auction with ID %s", bidID, auctionID)
+}
+
+func (ac *EnergyAuctionContract) GetAuctionWinner(ctx contractapi.TransactionContextInterface, resourceID string) (*AuctionWinner, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.IsActive {
+		return nil, fmt.Errorf("auction with ID %s is still active", auctionID)
+	}
+
+	if auction.WinnerID == "" {
+		return nil, fmt.Errorf("auction with ID %s ended without any bids", auctionID)
+	}
+
+	return &AuctionWinner{
+		ResourceID:  auction.ResourceID,
+		WinnerID:    auction.WinnerID,
+		WinnerPrice: auction.WinnerPrice,
+	}, nil
+}
+
+// ExplainWinner shows how the second price was reached once the auction has ended. Private
+// bid amounts are never published, so when any took part only PrivateBidCount is reported
+// alongside the winner and price.
+func (ac *EnergyAuctionContract) ExplainWinner(ctx contractapi.TransactionContextInterface, resourceID string) (*WinnerExplanation, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.IsActive {
+		return nil, fmt.Errorf("auction with ID %s is still active", auctionID)
+	}
+
+	if auction.WinnerID == "" {
+		return nil, fmt.Errorf("auction with ID %s ended without any bids", auctionID)
+	}
+
+	// EndAuction stores the public bids already sorted from highest to lowest.
+	explanation := WinnerExplanation{
+		ResourceID:      auction.ResourceID,
+		WinnerID:        auction.WinnerID,
+		WinnerPrice:     auction.WinnerPrice,
+		TopBids:         []int64{},
+		PrivateBidCount: len(auction.PrivateBidHashes),
+	}
+
+	if explanation.PrivateBidCount > 0 {
+		return &explanation, nil
+	}
+
+	for i := 0; i < len(auction.Bids) && i < 2; i++ {
+		explanation.TopBids = append(explanation.TopBids, auction.Bids[i].BidPrice)
+	}
+
+	if i := ac.priceSettingBid(auction.Bids, len(auction.Winners)); i >= 0 {
+		explanation.PriceSetByBidID = auction.Bids[i].BidID
+	}
+
+	return &explanation, nil
+}
+
+// GetTopBids returns the n highest public bids of a closed auction. Private bids and
+// unrevealed commitments are never part of the stored bid list, so they are not returned.
+func (ac *EnergyAuctionContract) GetTopBids(ctx contractapi.TransactionContextInterface, resourceID string, n int) ([]Bid, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of bids must be greater than zero")
+	}
+
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.IsActive {
+		return nil, fmt.Errorf("auction with ID %s is still active", auctionID)
+	}
+
+	bids := append([]Bid{}, auction.Bids...)
+	sort.SliceStable(bids, func(i, j int) bool {
+		return bids[i].BidPrice > bids[j].BidPrice
+	})
+
+	if n > len(bids) {
+		n = len(bids)
+	}
+
+	return bids[:n], nil
+}
+
+// ComputeRefund reports how much of the winner's own top bid exceeds the price they pay, so
+// an escrow holding the full bid knows what to return. When the winning bid was private it
+// can only be computed on peers in the private bid collection.
+func (ac *EnergyAuctionContract) ComputeRefund(ctx contractapi.TransactionContextInterface, resourceID string) (*WinnerRefund, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.IsActive {
+		return nil, fmt.Errorf("auction with ID %s is still active", auctionID)
+	}
+
+	if auction.WinnerID == "" {
+		return nil, fmt.Errorf("auction with ID %s ended without any bids", auctionID)
+	}
+
+	// EndAuction stores the public bids sorted, so only the head can be the winner's top bid.
+	winningBid := int64(0)
+	if len(auction.Bids) > 0 && auction.Bids[0].Bidder == auction.WinnerID {
+		winningBid = auction.Bids[0].BidPrice
+	}
+
+	if len(auction.PrivateBidHashes) > 0 {
+		privateBids, err := ac.fetchPrivateBids(ctx, auction)
+		if err != nil {
+			return nil, err
+		}
+		for _, bid := range privateBids {
+			if bid.Bidder == auction.WinnerID && (bid.ValidUntil == 0 || bid.ValidUntil >= auction.Deadline) {
+				winningBid = max(winningBid, bid.BidPrice)
+			}
+		}
+	}
+
+	return &WinnerRefund{
+		ResourceID:  auction.ResourceID,
+		WinnerID:    auction.WinnerID,
+		WinningBid:  winningBid,
+		WinnerPrice: auction.WinnerPrice,
+		Refund:      winningBid - auction.WinnerPrice,
+	}, nil
+}
+
+// GetBidSummary collapses the public bids of a closed auction to one entry per bidder,
+// highest bid first.
+func (ac *EnergyAuctionContract) GetBidSummary(ctx contractapi.TransactionContextInterface, resourceID string) ([]BidderSummary, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.IsActive {
+		return nil, fmt.Errorf("auction with ID %s is still active", auctionID)
+	}
+
+	summaries := map[string]*BidderSummary{}
+	for _, bid := range auction.Bids {
+		summary, ok := summaries[bid.Bidder]
+		if !ok {
+			summary = &BidderSummary{Bidder: bid.Bidder}
+			summaries[bid.Bidder] = summary
+		}
+		summary.HighestBid = max(summary.HighestBid, bid.BidPrice)
+		summary.BidCount++
+	}
+
+	summary := make([]BidderSummary, 0, len(summaries))
+	for _, bidderSummary := range summaries {
+		summary = append(summary, *bidderSummary)
+	}
+
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].HighestBid != summary[j].HighestBid {
+			return summary[i].HighestBid > summary[j].HighestBid
+		}
+		return summary[i].Bidder < summary[j].Bidder
+	})
+
+	return summary, nil
+}
+
+// GetBidderRank returns where the caller's best bid placed among all bidders of a closed
+// auction, counting from 1. A caller who did not bid gets rank 0. Private bids are included,
+// so auctions that had any can only be ranked on peers in the private bid collection.
+func (ac *EnergyAuctionContract) GetBidderRank(ctx contractapi.TransactionContextInterface, resourceID string) (*BidderRank, error) {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.IsActive {
+		return nil, fmt.Errorf("auction with ID %s is still active", auctionID)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	bids := append([]Bid{}, auction.Bids...)
+	if len(auction.PrivateBidHashes) > 0 {
+		privateBids, err := ac.fetchPrivateBids(ctx, auction)
+		if err != nil {
+			return nil, err
+		}
+		for _, bid := range privateBids {
+			if bid.ValidUntil == 0 || bid.ValidUntil >= auction.Deadline {
+				bids = append(bids, bid)
+			}
+		}
+	}
+	ac.sortBids(bids, auction.TieBreak)
+
+	// Only each bidder's best bid counts towards the ranking.
+	rank := BidderRank{ResourceID: auction.ResourceID}
+	ranked := map[string]bool{}
+	for _, bid := range bids {
+		if ranked[bid.Bidder] {
+			continue
+		}
+		ranked[bid.Bidder] = true
+		rank.TotalBidders++
+		if bid.Bidder == clientID {
+			rank.Rank = rank.TotalBidders
+			rank.Participated = true
+		}
+	}
+
+	return &rank, nil
+}
+
+func (ac *EnergyAuctionContract) GetAuctionHistory(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	auctionID := "auction:" + resourceID
+	results, err := ctx.GetStub().GetHistoryForKey(auctionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve auction history: %v", err)
+	}
+	defer results.Close()
+
+	history := []AuctionHistoryRecord{}
+	for results.HasNext() {
+		modification, err := results.Next()
+		if err != nil {
+			return "", err
+		}
+
+		record := AuctionHistoryRecord{
+			TxID:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}
+
+		if modification.Timestamp != nil {
+			record.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC().Format(time.RFC3339)
+		}
+
+		if !modification.IsDelete {
+			var auction EnergyAuction
+			if err := json.Unmarshal(modification.Value, &auction); err != nil {
+				return "", fmt.Errorf("failed to unmarshal auction: %v", err)
+			}
+			// Versions written while the auction ran are redacted just like GetAuction.
+			record.Value = ac.auctionView(&auction)
+		}
+
+		history = append(history, record)
+	}
+
+	return ac.marshalToString(history)
+}
+
+func (ac *EnergyAuctionContract) GetBidsByBidder(ctx contractapi.TransactionContextInterface) ([]BidderPosition, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	results, err := ctx.GetStub().GetStateByRange("auction:", "auction;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	positions := []BidderPosition{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var auction EnergyAuction
+		if err := json.Unmarshal(next.Value, &auction); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal auction: %v", err)
+		}
+
+		for _, bid := range auction.Bids {
+			if bid.Bidder == clientID {
+				positions = append(positions, BidderPosition{
+					ResourceID:    auction.ResourceID,
+					Bid:           bid,
+					AuctionActive: auction.IsActive,
+				})
+			}
+		}
+	}
+
+	return positions, nil
+}
+
+// Bid places a sealed bid for requestedVolume on the resource's open auction.
+// clientBidRef is optional. A retried Bid carrying a ref already processed for this
+// bidder is accepted without adding a second bid. A non-zero validUntil makes the bid
+// lapse at that time; zero keeps it valid through the end of the auction.
+func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount, requestedVolume float64, clientBidRef string, validUntil int64) error {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkBidFloor(resource, ac.toMinorUnits(bidAmount)); err != nil {
+		return err
+	}
+
+	if err := ac.checkRequestedVolume(resource, requestedVolume); err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline < currentTime {
+		return ac.EndAuction(ctx, resourceID)
+	}
+
+	if validUntil != 0 && validUntil < currentTime {
+		return fmt.Errorf("bid validity must not end in the past")
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientBidRef != "" {
+		bidRef := clientID + ":" + clientBidRef
+		if auction.ProcessedBidRefs[bidRef] {
+			return nil
+		}
+		if auction.ProcessedBidRefs == nil {
+			auction.ProcessedBidRefs = map[string]bool{}
+		}
+		auction.ProcessedBidRefs[bidRef] = true
+	}
+
+	bid := Bid{
+		BidID:           fmt.Sprintf("%s:%s:%d", auctionID, clientID, currentTime),
+		ResourceID:      resourceID,
+		Bidder:          clientID,
+		BidPrice:        ac.toMinorUnits(bidAmount),
+		RequestedVolume: requestedVolume,
+		Timestamp:       currentTime,
+		ValidUntil:      validUntil,
+	}
+
+	auction.Bids = append(auction.Bids, bid)
+
+	return ac.storeObject(ctx, auctionID, *auction)
+}
+
+// GetAuctionsIParticipatedIn lists every auction the caller has bid or committed in. Bids stay
+// sealed while an auction runs, so whether the caller holds the top bid is only reported once
+// it has ended; until then RankHidden is set.
+func (ac *EnergyAuctionContract) GetAuctionsIParticipatedIn(ctx contractapi.TransactionContextInterface) ([]ParticipatedAuction, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	results, err := ctx.GetStub().GetStateByRange("auction:", "auction;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	participated := []ParticipatedAuction{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var auction EnergyAuction
+		if err := json.Unmarshal(next.Value, &auction); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal auction: %v", err)
+		}
+
+		if !ac.hasParticipated(&auction, next.Key, clientID) {
+			continue
+		}
+
+		participated = append(participated, ParticipatedAuction{
+			ResourceID: auction.ResourceID,
+			IsActive:   auction.IsActive,
+			IsTopBid:   !auction.IsActive && auction.WinnerID == clientID,
+			RankHidden: auction.IsActive,
+		})
+	}
+
+	return participated, nil
+}
+
+func (ac *EnergyAuctionContract) RetractBid(ctx contractapi.TransactionContextInterface, resourceID, bidID string) error {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	return ac.storeObject(ctx, resourceID, resource)
-}
-
-func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
-	resource, err := ac.fetchResource(ctx, resourceID)
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return ac.marshalToString(resource)
-}
 
-func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContextInterface) ([]EnergyResource, error) {
-	results, err := ctx.GetStub().GetStateByRange("", "")
+	if auction.Deadline < currentTime {
+		return fmt.Errorf("auction with ID %s has expired", auctionID)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to get client ID: %v", err)
 	}
-	defer results.Close()
 
-	var resources []EnergyResource
-	for results.HasNext() {
-		next, err := results.Next()
-		if err != nil {
-			return nil, err
+	for i, bid := range auction.Bids {
+		if bid.BidID != bidID || bid.Bidder != clientID {
+			continue
 		}
 
-		var resource EnergyResource
-		err = json.Unmarshal(next.Value, &resource)
-		if err != nil { // Not an EnergyResource object
-			return nil, err
+		if currentTime-bid.Timestamp > retractWindowSeconds {
+			return fmt.Errorf("bid %s can no longer be retracted; bids are binding %d seconds after placement", bidID, retractWindowSeconds)
 		}
-		resources = append(resources, resource)
-	}
 
-	sort.Slice(resources, func(i, j int) bool {
-		return resources[i].Price < resources[j].Price
-	})
+		auction.Bids = append(auction.Bids[:i], auction.Bids[i+1:]...)
+		return ac.storeObject(ctx, auctionID, *auction)
+	}
 
-	return resources, nil
+	return fmt.Errorf("bid %s placed by the caller was not found in auction with ID %s", bidID, auctionID)
 }
 
-func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration int64) error {
-	resource, err := ac.fetchResource(ctx, resourceID)
+func (ac *EnergyAuctionContract) CommitBid(ctx contractapi.TransactionContextInterface, resourceID string, hashHex string) error {
+	auctionID := "auction:" + resourceID
+	auction, err := ac.fetchAuction(ctx, auctionID)
 	if err != nil {
 		return err
 	}
 
-	if resource.AuctionStatus {
-		return fmt.Errorf("auction for resource with ID %s is already active", resourceID)
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	if !resource.IsAvailable {
-		return fmt.Errorf("resource with ID %s is not available", resourceID)
+	// Without a reveal window a commitment could never be opened.
+	if auction.RevealDeadline <= auction.Deadline {
+		return fmt.Errorf("auction with ID %s has no reveal phase and does not accept commitments", auctionID)
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if _, err := hex.DecodeString(hashHex); err != nil || len(hashHex) != sha256.Size*2 {
+		return fmt.Errorf("commitment must be a hex encoded SHA-256 hash")
+	}
+
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	auction := EnergyAuction{
-		ResourceID: resourceID,
-		Deadline:   currentTimestamp.Seconds + duration,
-		Bids:       []Bid{},
-		IsActive:   true,
+	if auction.Deadline < currentTime {
+		return fmt.Errorf("commit phase for auction with ID %s has ended", auctionID)
 	}
-	resource.AuctionStatus = true
 
-	if err := ac.storeObject(ctx, resourceID, *resource); err != nil {
-		return err
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
 	}
 
-	return ac.storeObject(ctx, "auction:"+resourceID, auction)
+	if auction.Commitments == nil {
+		auction.Commitments = map[string]string{}
+	}
+	auction.Commitments[clientID] = strings.ToLower(hashHex)
+
+	return ac.storeObject(ctx, auctionID, *auction)
 }
 
-func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+// The commitment is the SHA-256 hash of "<clientID>:<resourceID>:<bidAmount>:<requestedVolume>:<nonce>",
+// where both numbers are formatted without trailing zeros (e.g. "20" or "20.5"). Binding the bidder and
+// resource stops a copied commitment from being revealed by anyone else or in another auction.
+func (ac *EnergyAuctionContract) RevealBid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount, requestedVolume float64, nonce string) error {
 	auctionID := "auction:" + resourceID
 	auction, err := ac.fetchAuction(ctx, auctionID)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline >= currentTime {
+		return fmt.Errorf("reveal phase for auction with ID %s has not yet started", auctionID)
+	}
+
+	if auction.RevealDeadline < currentTime {
+		return fmt.Errorf("reveal phase for auction with ID %s has ended", auctionID)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return "", fmt.Errorf("failed to get current block timestamp: %v", err)
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	commitment, ok := auction.Commitments[clientID]
+	if !ok {
+		return fmt.Errorf("no bid commitment found for client in auction with ID %s", auctionID)
+	}
+
+	preimage := clientID + ":" + resourceID + ":" + strconv.FormatFloat(bidAmount, 'f', -1, 64) + ":" + strconv.FormatFloat(requestedVolume, 'f', -1, 64) + ":" + nonce
+	hash := sha256.Sum256([]byte(preimage))
+	if hex.EncodeToString(hash[:]) != commitment {
+		return fmt.Errorf("revealed bid does not match commitment")
+	}
+
+	if err := ac.checkBidFloor(resource, ac.toMinorUnits(bidAmount)); err != nil {
+		return err
+	}
+
+	if err := ac.checkRequestedVolume(resource, requestedVolume); err != nil {
+		return err
 	}
 
-	if auction.Deadline > currentTimestamp.Seconds {
-		auction.Bids = []Bid{}
+	bid := Bid{
+		BidID:           fmt.Sprintf("%s:%s:%d", auctionID, clientID, currentTime),
+		ResourceID:      resourceID,
+		Bidder:          clientID,
+		BidPrice:        ac.toMinorUnits(bidAmount),
+		RequestedVolume: requestedVolume,
+		Timestamp:       currentTime,
 	}
 
-	return ac.marshalToString(auction)
+	delete(auction.Commitments, clientID)
+	auction.Bids = append(auction.Bids, bid)
+
+	return ac.storeObject(ctx, auctionID, *auction)
 }
 
-func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount float64) error {
+// PrivateBid reads the bid from the transient field "bid" as {"bidAmount", "requestedVolume",
+// "salt"} so the amount never appears in the transaction proposal.
+func (ac *EnergyAuctionContract) PrivateBid(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to get transient data: %v", err)
+	}
+
+	bidInputJSON, ok := transientMap["bid"]
+	if !ok {
+		return fmt.Errorf("bid must be supplied in the transient field \"bid\"")
+	}
+
+	var bidInput PrivateBidInput
+	if err := json.Unmarshal(bidInputJSON, &bidInput); err != nil {
+		return fmt.Errorf("failed to unmarshal private bid: %v", err)
+	}
+
+	if len(bidInput.Salt) < minPrivateBidSaltLength {
+		return fmt.Errorf("private bid salt must be at least %d characters long", minPrivateBidSaltLength)
+	}
+
 	auctionID := "auction:" + resourceID
 	auction, err := ac.fetchAuction(ctx, auctionID)
 	if err != nil {
@@ -156,21 +1211,29 @@ func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface
 		return err
 	}
 
-	if bidAmount <= resource.Price {
-		return fmt.Errorf("bid amount must be higher than resource price")
+	if err := ac.checkBidFloor(resource, ac.toMinorUnits(bidInput.BidAmount)); err != nil {
+		return err
+	}
+
+	if err := ac.checkRequestedVolume(resource, bidInput.RequestedVolume); err != nil {
+		return err
 	}
 
 	if !auction.IsActive {
 		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	if auction.Deadline < currentTimestamp.Seconds {
-		return ac.EndAuction(ctx, resourceID)
+	if auction.Deadline < currentTime {
+		return fmt.Errorf("auction with ID %s has expired", auctionID)
+	}
+
+	if bidInput.ValidUntil != 0 && bidInput.ValidUntil < currentTime {
+		return fmt.Errorf("bid validity must not end in the past")
 	}
 
 	clientID, err := ctx.GetClientIdentity().GetID()
@@ -179,14 +1242,29 @@ func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface
 	}
 
 	bid := Bid{
-		BidID:      fmt.Sprintf("%s:%s:%d", auctionID, clientID, currentTimestamp.Seconds),
-		ResourceID: resourceID,
-		Bidder:     clientID,
-		BidPrice:   bidAmount,
-		Timestamp:  currentTimestamp.Seconds,
+		BidID:           fmt.Sprintf("%s:%s:%d", auctionID, clientID, currentTime),
+		ResourceID:      resourceID,
+		Bidder:          clientID,
+		BidPrice:        ac.toMinorUnits(bidInput.BidAmount),
+		RequestedVolume: bidInput.RequestedVolume,
+		Timestamp:       currentTime,
+		ValidUntil:      bidInput.ValidUntil,
 	}
 
-	auction.Bids = append(auction.Bids, bid)
+	bidJSON, err := json.Marshal(PrivateBidRecord{Bid: bid, Salt: bidInput.Salt})
+	if err != nil {
+		return fmt.Errorf("failed to marshal bid: %v", err)
+	}
+
+	if err := ctx.GetStub().PutPrivateData(privateBidCollection, bid.BidID, bidJSON); err != nil {
+		return fmt.Errorf("failed to store private bid: %v", err)
+	}
+
+	hash := sha256.Sum256(bidJSON)
+	if auction.PrivateBidHashes == nil {
+		auction.PrivateBidHashes = map[string]string{}
+	}
+	auction.PrivateBidHashes[bid.BidID] = hex.EncodeToString(hash[:])
 
 	return ac.storeObject(ctx, auctionID, *auction)
 }
@@ -202,18 +1280,39 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 		return fmt.Errorf("auction with ID %s is not active", auctionID)
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	currentTime, err := ac.currentTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return err
 	}
 
-	if auction.Deadline > currentTimestamp.Seconds {
+	if auction.Deadline > currentTime {
 		return fmt.Errorf("auction with ID %s has not yet expired", auctionID)
 	}
 
-	sort.Slice(auction.Bids, func(i, j int) bool {
-		return auction.Bids[i].BidPrice > auction.Bids[j].BidPrice
-	})
+	if auction.RevealDeadline > currentTime {
+		return fmt.Errorf("reveal phase for auction with ID %s has not yet ended", auctionID)
+	}
+
+	privateBids, err := ac.fetchPrivateBids(ctx, auction)
+	if err != nil {
+		return err
+	}
+	auction.Bids = append(auction.Bids, privateBids...)
+
+	// Bids that lapsed before the deadline are dropped before the winner and price are chosen,
+	// but their bidders are still reported as losers.
+	validBids, lapsedBids := []Bid{}, []Bid{}
+	for _, bid := range auction.Bids {
+		if bid.ValidUntil == 0 || bid.ValidUntil >= auction.Deadline {
+			validBids = append(validBids, bid)
+		} else {
+			lapsedBids = append(lapsedBids, bid)
+		}
+	}
+	auction.Bids = validBids
+
+	// Commitments that were never revealed are not bids and take no part in the outcome.
+	ac.sortBids(auction.Bids, auction.TieBreak)
 
 	auction.IsActive = false
 
@@ -224,24 +1323,320 @@ func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextIn
 
 	resource.AuctionStatus = false
 
+	auction.Winners, auction.UnallocatedVolume = ac.allocateVolume(auction.Bids, resource.RemainingVolume)
+
+	// The winner pays the same clearing price as every other allocation.
 	if len(auction.Bids) > 0 {
 		resource.IsAvailable = false
 		auction.WinnerID = auction.Bids[0].Bidder
-		if len(auction.Bids) > 1 {
-			auction.WinnerPrice = auction.Bids[1].BidPrice
-		} else {
-			auction.WinnerPrice = auction.Bids[0].BidPrice
+		auction.WinnerPrice = auction.Winners[0].ClearingPrice
+
+		if err := ac.checkSettlementInvariant(auction); err != nil {
+			return err
+		}
+	}
+
+	resource.RemainingVolume = auction.UnallocatedVolume
+	auction.LosingBidders = ac.losingBidders(auction, lapsedBids)
+
+	// Private amounts decide the outcome but are never written back to the public auction.
+	publicBids := []Bid{}
+	for _, bid := range auction.Bids {
+		if _, ok := auction.PrivateBidHashes[bid.BidID]; !ok {
+			publicBids = append(publicBids, bid)
 		}
 	}
+	auction.Bids = publicBids
 
 	if err := ac.storeObject(ctx, auction.ResourceID, *resource); err != nil {
 		return err
 	}
 
+	if err := ctx.GetStub().DelState(lockKeyPrefix + resourceID); err != nil {
+		return fmt.Errorf("failed to release resource lock: %v", err)
+	}
+
 	return ac.storeObject(ctx, auctionID, *auction)
 }
 
 // Helper functions
+
+// currentTime returns the transaction timestamp in Unix seconds. A nil timestamp is an error.
+func (ac *EnergyAuctionContract) currentTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current block timestamp: %v", err)
+	}
+	if timestamp == nil {
+		return 0, fmt.Errorf("transaction timestamp is not set")
+	}
+	return timestamp.Seconds, nil
+}
+
+func (ac *EnergyAuctionContract) toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (ac *EnergyAuctionContract) fromMinorUnits(units int64) float64 {
+	return float64(units) / minorUnitsPerUnit
+}
+
+// sortBids orders bids from highest to lowest price, breaking ties by time according to
+// tieBreak and finally by bid ID.
+func (ac *EnergyAuctionContract) sortBids(bids []Bid, tieBreak string) {
+	sort.Slice(bids, func(i, j int) bool {
+		if bids[i].BidPrice != bids[j].BidPrice {
+			return bids[i].BidPrice > bids[j].BidPrice
+		}
+		if bids[i].Timestamp != bids[j].Timestamp {
+			if tieBreak == tieBreakLatest {
+				return bids[i].Timestamp > bids[j].Timestamp
+			}
+			return bids[i].Timestamp < bids[j].Timestamp
+		}
+		return bids[i].BidID < bids[j].BidID
+	})
+}
+
+func (ac *EnergyAuctionContract) allocateVolume(sortedBids []Bid, volume float64) ([]Allocation, float64) {
+	allocations := []Allocation{}
+	remaining := volume
+
+	for _, bid := range sortedBids {
+		if remaining <= 0 {
+			break
+		}
+
+		allocated := math.Min(bid.RequestedVolume, remaining)
+		allocations = append(allocations, Allocation{
+			Bidder:          bid.Bidder,
+			AllocatedVolume: allocated,
+		})
+		remaining -= allocated
+	}
+
+	// Every winner pays the price of the bid that set it, see priceSettingBid. When demand
+	// falls short of supply the leftover volume is reported as unallocated.
+	if i := ac.priceSettingBid(sortedBids, len(allocations)); i >= 0 {
+		for j := range allocations {
+			allocations[j].ClearingPrice = sortedBids[i].BidPrice
+		}
+	}
+
+	return allocations, remaining
+}
+
+// priceSettingBid returns the index of the bid that sets the clearing price once the first
+// accepted bids have been allocated: the highest bid left without any volume, which is the
+// second price when one bidder takes everything. When no bid was displaced the lowest
+// accepted bid sets the price. It returns -1 when there are no bids.
+func (ac *EnergyAuctionContract) priceSettingBid(sortedBids []Bid, accepted int) int {
+	if accepted < len(sortedBids) {
+		return accepted
+	}
+	return len(sortedBids) - 1
+}
+
+// fetchPrivateBids loads every private bid of the auction and checks it against its public
+// hash. It only succeeds on peers that are members of the private bid collection.
+func (ac *EnergyAuctionContract) fetchPrivateBids(ctx contractapi.TransactionContextInterface, auction *EnergyAuction) ([]Bid, error) {
+	bidIDs := make([]string, 0, len(auction.PrivateBidHashes))
+	for bidID := range auction.PrivateBidHashes {
+		bidIDs = append(bidIDs, bidID)
+	}
+	sort.Strings(bidIDs)
+
+	bids := []Bid{}
+	for _, bidID := range bidIDs {
+		bidJSON, err := ctx.GetStub().GetPrivateData(privateBidCollection, bidID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve private bid: %v", err)
+		}
+		if bidJSON == nil {
+			return nil, fmt.Errorf("private bid %s is not available on this peer", bidID)
+		}
+
+		hash := sha256.Sum256(bidJSON)
+		if hex.EncodeToString(hash[:]) != auction.PrivateBidHashes[bidID] {
+			return nil, fmt.Errorf("private bid %s does not match its recorded hash", bidID)
+		}
+
+		var record PrivateBidRecord
+		if err := json.Unmarshal(bidJSON, &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal private bid: %v", err)
+		}
+		bids = append(bids, record.Bid)
+	}
+	return bids, nil
+}
+
+// checkSettlementInvariant guards against a winner who is not the top bid after sorting
+// or a price above what the winner offered.
+func (ac *EnergyAuctionContract) checkSettlementInvariant(auction *EnergyAuction) error {
+	topBid := auction.Bids[0]
+	if auction.WinnerID != topBid.Bidder {
+		return fmt.Errorf("settlement invariant violated: winner %s does not hold the top bid %s", auction.WinnerID, topBid.BidID)
+	}
+	if auction.WinnerPrice > topBid.BidPrice {
+		return fmt.Errorf("settlement invariant violated: winner price %.2f exceeds top bid of %.2f", ac.fromMinorUnits(auction.WinnerPrice), ac.fromMinorUnits(topBid.BidPrice))
+	}
+	return nil
+}
+
+// losingBidders lists each bidder that neither won nor received an allocation, in bid order,
+// followed by those whose only bids lapsed.
+func (ac *EnergyAuctionContract) losingBidders(auction *EnergyAuction, lapsedBids []Bid) []string {
+	winners := map[string]bool{auction.WinnerID: true}
+	for _, allocation := range auction.Winners {
+		winners[allocation.Bidder] = true
+	}
+
+	losers := []string{}
+	for _, bids := range [][]Bid{auction.Bids, lapsedBids} {
+		for _, bid := range bids {
+			if winners[bid.Bidder] {
+				continue
+			}
+			winners[bid.Bidder] = true
+			losers = append(losers, bid.Bidder)
+		}
+	}
+	return losers
+}
+
+// hasParticipated reports whether the client placed a public, committed or private bid. Private
+// bid IDs embed the bidder, which is the only trace they leave on the public auction.
+func (ac *EnergyAuctionContract) hasParticipated(auction *EnergyAuction, auctionID, clientID string) bool {
+	for _, bid := range auction.Bids {
+		if bid.Bidder == clientID {
+			return true
+		}
+	}
+
+	if _, ok := auction.Commitments[clientID]; ok {
+		return true
+	}
+
+	for bidID := range auction.PrivateBidHashes {
+		if strings.HasPrefix(bidID, auctionID+":"+clientID+":") {
+			return true
+		}
+	}
+	return false
+}
+
+func (ac *EnergyAuctionContract) checkBidFloor(resource *EnergyResource, bidUnits int64) error {
+	if resource.PricingMode == pricingModeTotal {
+		floor := int64(math.Round(float64(resource.Price) * resource.RemainingVolume))
+		if bidUnits <= floor {
+			return fmt.Errorf("bid amount must be higher than resource price times volume")
+		}
+		return nil
+	}
+	if bidUnits <= resource.Price {
+		return fmt.Errorf("bid amount must be higher than resource price")
+	}
+	return nil
+}
+
+func (ac *EnergyAuctionContract) checkRequestedVolume(resource *EnergyResource, requestedVolume float64) error {
+	if requestedVolume <= 0 {
+		return fmt.Errorf("requested volume must be greater than zero")
+	}
+	if requestedVolume > resource.RemainingVolume {
+		return fmt.Errorf("requested volume must not exceed remaining resource volume of %f", resource.RemainingVolume)
+	}
+	if resource.PricingMode == pricingModeTotal && requestedVolume != resource.RemainingVolume {
+		return fmt.Errorf("under total pricing the requested volume must be the whole remaining volume of %f", resource.RemainingVolume)
+	}
+	return nil
+}
+
+// lockResource takes the lock for auctionType unless this chaincode or one of its lock peers
+// already holds a lock on the resource. Taking a lock this chaincode already holds for the
+// same type succeeds.
+func (ac *EnergyAuctionContract) lockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		if lock.AuctionType != auctionType {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction", resourceID, lock.AuctionType)
+		}
+		return nil
+	}
+
+	if err := ac.checkPeerLocks(ctx, resourceID); err != nil {
+		return err
+	}
+
+	return ac.storeObject(ctx, lockKeyPrefix+resourceID, ResourceLock{ResourceID: resourceID, AuctionType: auctionType})
+}
+
+// checkPeerLocks fails if any lock peer holds a lock on the resource. Reads made through
+// InvokeChaincode on the same channel join this transaction's read set, so a peer lock taken
+// concurrently invalidates one of the two transactions.
+func (ac *EnergyAuctionContract) checkPeerLocks(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedPeers, err := ctx.GetStub().GetState(lockPeersKey)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve lock peers: %v", err)
+	}
+	if fetchedPeers == nil {
+		return nil
+	}
+
+	var chaincodeNames []string
+	if err := json.Unmarshal(fetchedPeers, &chaincodeNames); err != nil {
+		return fmt.Errorf("failed to unmarshal lock peers: %v", err)
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		response := ctx.GetStub().InvokeChaincode(chaincodeName, [][]byte{[]byte("GetResourceLock"), []byte(resourceID)}, "")
+		if response.Status != shim.OK {
+			return fmt.Errorf("failed to query resource lock in chaincode %s: %s", chaincodeName, response.Message)
+		}
+		if auctionType := string(response.Payload); auctionType != "" {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction in chaincode %s", resourceID, auctionType, chaincodeName)
+		}
+	}
+	return nil
+}
+
+// checkOwnerOrAdmin lets only the resource owner and admins perform action, which completes the
+// error message. Resources stored without an owner, or no longer stored at all, need an admin.
+func (ac *EnergyAuctionContract) checkOwnerOrAdmin(ctx contractapi.TransactionContextInterface, resourceID string, resource *EnergyResource, action string) error {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if resource != nil && resource.Owner != "" && resource.Owner == clientID {
+		return nil
+	}
+
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("only the owner of resource with ID %s or an admin can %s", resourceID, action)
+	}
+	return nil
+}
+
+func (ac *EnergyAuctionContract) fetchLock(ctx contractapi.TransactionContextInterface, resourceID string) (*ResourceLock, error) {
+	fetchedLock, err := ctx.GetStub().GetState(lockKeyPrefix + resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resource lock: %v", err)
+	}
+	if fetchedLock == nil {
+		return nil, nil
+	}
+
+	var lock ResourceLock
+	if err := json.Unmarshal(fetchedLock, &lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource lock: %v", err)
+	}
+	return &lock, nil
+}
+
 func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error) {
 	jsonData, err := json.Marshal(v)
 	if err != nil {
@@ -250,6 +1645,11 @@ func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error)
 	return string(jsonData), nil
 }
 
+// Resources share the key space with auction, lock and config records, so range scans must skip those.
+func (ac *EnergyAuctionContract) isResourceKey(key string) bool {
+	return !strings.HasPrefix(key, "auction:") && !strings.HasPrefix(key, lockKeyPrefix) && key != lockPeersKey
+}
+
 func (ac *EnergyAuctionContract) checkResourceExists(ctx contractapi.TransactionContextInterface, resourceID string) error {
 	fetchedResource, err := ctx.GetStub().GetState(resourceID)
 	if err != nil {
@@ -274,6 +1674,10 @@ func (ac *EnergyAuctionContract) fetchResource(ctx contractapi.TransactionContex
 	if err := json.Unmarshal(fetchedResource, &resource); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal resource: %v", err)
 	}
+	// Resources stored before remaining volume was tracked have never been allocated.
+	if resource.RemainingVolume == 0 && resource.IsAvailable {
+		resource.RemainingVolume = resource.Volume
+	}
 	return &resource, nil
 }
 
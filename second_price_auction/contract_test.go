@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type testIdentity struct {
+	id    string
+	admin bool
+}
+
+func (ti testIdentity) GetID() (string, error) {
+	return ti.id, nil
+}
+
+func (ti testIdentity) GetMSPID() (string, error) {
+	return "Org1MSP", nil
+}
+
+func (ti testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	if attrName == adminAttribute && ti.admin {
+		return "true", true, nil
+	}
+	return "", false, nil
+}
+
+func (ti testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	if value, found, _ := ti.GetAttributeValue(attrName); !found || value != attrValue {
+		return fmt.Errorf("attribute %s is not %s", attrName, attrValue)
+	}
+	return nil
+}
+
+func (ti testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{}, nil
+}
+
+func newTestContext(stub *shimtest.MockStub, clientID string) *contractapi.TransactionContext {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(testIdentity{id: clientID})
+	return ctx
+}
+
+func TestGetMeritOrderSkipsAuctionRecords(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction", nil)
+	stub.MockTransactionStart("tx1")
+	ctx := newTestContext(stub, "producer")
+
+	if err := ac.SubmitEnergyResource(ctx, "res1", 10, 5, "solar", ""); err != nil {
+		t.Fatalf("SubmitEnergyResource(res1) failed: %v", err)
+	}
+	if err := ac.SubmitEnergyResource(ctx, "res2", 20, 3, "wind", ""); err != nil {
+		t.Fatalf("SubmitEnergyResource(res2) failed: %v", err)
+	}
+	if err := ac.StartAuction(ctx, "res1", 3600, 0, tieBreakEarliest); err != nil {
+		t.Fatalf("StartAuction failed: %v", err)
+	}
+	if err := ac.Bid(newTestContext(stub, "consumer"), "res1", 6, 5, "", 0); err != nil {
+		t.Fatalf("Bid failed: %v", err)
+	}
+
+	resources, err := ac.GetMeritOrder(ctx)
+	if err != nil {
+		t.Fatalf("GetMeritOrder failed: %v", err)
+	}
+
+	if len(resources) != 2 {
+		t.Fatalf("expected 2 resources in the merit order, got %d: %+v", len(resources), resources)
+	}
+	if resources[0].Type != "wind" || resources[0].Price != 300 {
+		t.Errorf("expected the wind resource at 300 first, got %+v", resources[0])
+	}
+	if resources[1].Type != "solar" || resources[1].Price != 500 {
+		t.Errorf("expected the solar resource at 500 second, got %+v", resources[1])
+	}
+	for _, resource := range resources {
+		if resource.Volume == 0 {
+			t.Errorf("merit order holds a zero-value resource decoded from a non-resource record: %+v", resource)
+		}
+	}
+}
+
+func TestRelistResourceRequiresOwnerOrAdmin(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction", nil)
+	stub.MockTransactionStart("tx1")
+
+	if err := ac.SubmitEnergyResource(newTestContext(stub, "producer"), "res1", 10, 5, "solar", ""); err != nil {
+		t.Fatalf("SubmitEnergyResource failed: %v", err)
+	}
+
+	err := ac.RelistResource(newTestContext(stub, "consumer"), "res1")
+	if err == nil || !strings.Contains(err.Error(), "only the owner") {
+		t.Errorf("expected a non-owner relisting to be rejected, got %v", err)
+	}
+
+	if err := ac.RelistResource(newTestContext(stub, "producer"), "res1"); err != nil {
+		t.Errorf("expected the owner to relist, got %v", err)
+	}
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(testIdentity{id: "operator", admin: true})
+	if err := ac.RelistResource(ctx, "res1"); err != nil {
+		t.Errorf("expected an admin to relist, got %v", err)
+	}
+}
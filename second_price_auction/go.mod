@@ -2,6 +2,8 @@ module github.com/khalidzahra/second_price_auction
 
 go 1.22.4
 
+require github.com/khalidzahra/contractinfo v0.0.0
+
 require (
 	github.com/go-openapi/jsonpointer v0.20.0 // indirect
 	github.com/go-openapi/jsonreference v0.20.2 // indirect
@@ -30,3 +32,5 @@ require (
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/khalidzahra/contractinfo => ../contractinfo
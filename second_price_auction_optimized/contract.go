@@ -2,63 +2,489 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"slices"
 	"sort"
+	"strings"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/khalidzahra/contractinfo"
 )
 
 type EnergyResource struct {
-	Volume        float64 `json:"volume"`
-	Price         float64 `json:"price"`
-	Type          string  `json:"type"`
-	IsAvailable   bool    `json:"isAvailable"`
-	AuctionStatus bool    `json:"auctionStatus"`
+	Volume          float64           `json:"volume"`
+	Price           int64             `json:"priceMinor"`
+	Type            string            `json:"type"`
+	IsAvailable     bool              `json:"isAvailable"`
+	AuctionStatus   bool              `json:"auctionStatus"`
+	Owner           string            `json:"owner"`
+	CarbonIntensity float64           `json:"carbonIntensity"`
+	Region          string            `json:"region"`
+	Metadata        map[string]string `json:"metadata"`
+}
+
+type ResourceWithID struct {
+	ResourceID string         `json:"resourceID"`
+	Resource   EnergyResource `json:"resource"`
 }
 
 type EnergyAuction struct {
-	ResourceID  string  `json:"resourceID"`
-	Deadline    int64   `json:"deadline"`
-	Bids        []Bid   `json:"bids"`
-	WinnerID    string  `json:"winnerID"`
-	WinnerPrice float64 `json:"winnerPrice"`
-	IsActive    bool    `json:"status"`
+	SchemaVersion     int          `json:"schemaVersion"`
+	AuctionID         string       `json:"auctionID"`
+	ResourceID        string       `json:"resourceID"`
+	Deadline          int64        `json:"deadline"`
+	Bids              []Bid        `json:"bids"`
+	WinnerID          string       `json:"winnerID"`
+	WinnerPrice       int64        `json:"winnerPriceMinor"`
+	RunnerUpID        string       `json:"runnerUpID"`
+	RunnerUpPrice     int64        `json:"runnerUpPriceMinor"`
+	ReservePrice      int64        `json:"reservePriceMinor"`
+	MaxBidPerBidder   int64        `json:"maxBidPerBidderMinor"`
+	IsOpen            bool         `json:"isOpen"`
+	MinBidders        int          `json:"minBidders"`
+	BidFloor          int64        `json:"bidFloorMinor"`
+	PriceDecimals     int          `json:"priceDecimals"`
+	FinalizedTxID     string       `json:"finalizedTxID"`
+	Allocations       []Allocation `json:"allocations"`
+	UnallocatedVolume float64      `json:"unallocatedVolume"`
+	IsActive          bool         `json:"status"`
 }
 
 type Bid struct {
-	BidID      string  `json:"bidID"`
-	ResourceID string  `json:"resourceID"`
-	Bidder     string  `json:"bidder"`
-	BidPrice   float64 `json:"bidPrice"`
-	Timestamp  int64   `json:"timestamp"`
+	BidID           string  `json:"bidID"`
+	ResourceID      string  `json:"resourceID"`
+	Bidder          string  `json:"bidder"`
+	BidPrice        int64   `json:"bidPriceMinor"`
+	RequestedVolume float64 `json:"requestedVolume"`
+	Timestamp       int64   `json:"timestamp"`
+}
+
+type Allocation struct {
+	Bidder string  `json:"bidder"`
+	Volume float64 `json:"volume"`
+}
+
+// Clients carrying this attribute set to "true" may query other bidders' won volume.
+const auditorAttribute = "auditor"
+
+// Clients carrying this attribute set to "true" may change the allowed resource types, manage
+// the lock of any resource and choose the lock peers.
+const adminAttribute = "admin"
+
+// Resource types accepted until an admin stores a different list with SetAllowedTypes.
+var defaultAllowedTypes = []string{"solar", "wind", "hydro", "battery", "gas"}
+
+// Resources submitted without a carbon intensity (gCO2/kWh) record it as unknown and are
+// never treated as green.
+const unknownCarbonIntensity = -1
+
+// Auctions written before schema versioning decode as version 0 and are upgraded on read.
+// Version 2 added price precision.
+const auctionSchemaVersion = 2
+
+// Prices are held in minor units, so they carry at most this many decimal places.
+const maxPriceDecimals = 2
+
+// Every stored amount, from reserves to settlement prices, is held in cents.
+const minorUnitsPerUnit = 100
+
+// Resources, auctions and bids from before the move to cents hold float amounts under the old
+// field names; decoding converts them, independent of the auction schema version.
+func legacyMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (r *EnergyResource) UnmarshalJSON(data []byte) error {
+	type stored EnergyResource
+	legacy := struct {
+		*stored
+		LegacyPrice *float64 `json:"price"`
+	}{stored: (*stored)(r)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyPrice != nil {
+		r.Price = legacyMinorUnits(*legacy.LegacyPrice)
+	}
+	return nil
+}
+
+func (a *EnergyAuction) UnmarshalJSON(data []byte) error {
+	type stored EnergyAuction
+	legacy := struct {
+		*stored
+		LegacyWinnerPrice *float64 `json:"winnerPrice"`
+	}{stored: (*stored)(a)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyWinnerPrice != nil {
+		a.WinnerPrice = legacyMinorUnits(*legacy.LegacyWinnerPrice)
+	}
+	return nil
+}
+
+func (b *Bid) UnmarshalJSON(data []byte) error {
+	type stored Bid
+	legacy := struct {
+		*stored
+		LegacyBidPrice *float64 `json:"bidPrice"`
+	}{stored: (*stored)(b)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.LegacyBidPrice != nil {
+		b.BidPrice = legacyMinorUnits(*legacy.LegacyBidPrice)
+	}
+	return nil
+}
+
+type Settlement struct {
+	AuctionID   string       `json:"auctionID"`
+	ResourceID  string       `json:"resourceID"`
+	Buyer       string       `json:"buyer"`
+	Seller      string       `json:"seller"`
+	Volume      float64      `json:"volume"`
+	Price       int64        `json:"priceMinor"`
+	Allocations []Allocation `json:"allocations"`
+	Timestamp   int64        `json:"timestamp"`
+}
+
+type BundleBid struct {
+	BundleID    string   `json:"bundleID"`
+	Bidder      string   `json:"bidder"`
+	ResourceIDs []string `json:"resourceIDs"`
+	AuctionIDs  []string `json:"auctionIDs"`
+	TotalPrice  int64    `json:"totalPriceMinor"`
+	Deadline    int64    `json:"deadline"`
+	Timestamp   int64    `json:"timestamp"`
+	Status      string   `json:"status"`
+}
+
+type OwnershipTransferredEvent struct {
+	ResourceID    string `json:"resourceID"`
+	PreviousOwner string `json:"previousOwner"`
+	NewOwner      string `json:"newOwner"`
+}
+
+type UnsoldRecord struct {
+	AuctionID  string `json:"auctionID"`
+	ResourceID string `json:"resourceID"`
+	Reason     string `json:"reason"`
+	HighestBid int64  `json:"highestBidMinor"`
+	Reserve    int64  `json:"reserveMinor"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+type StateExport struct {
+	Resources     []ResourceWithID `json:"resources"`
+	Auctions      []EnergyAuction  `json:"auctions"`
+	Settlements   []Settlement     `json:"settlements"`
+	UnsoldRecords []UnsoldRecord   `json:"unsoldRecords"`
+	Bundles       []BundleBid      `json:"bundles"`
+	AllowedTypes  []string         `json:"allowedTypes"`
+	LockPeers     []string         `json:"lockPeers"`
+	Locks         []ResourceLock   `json:"locks"`
+}
+
+type WonAuction struct {
+	AuctionID       string  `json:"auctionID"`
+	ResourceID      string  `json:"resourceID"`
+	WinnerPrice     int64   `json:"winnerPriceMinor"`
+	Volume          float64 `json:"volume"`
+	AllocatedVolume float64 `json:"allocatedVolume"`
+	Type            string  `json:"type"`
+	Deadline        int64   `json:"deadline"`
+}
+
+type ExpiredAuction struct {
+	AuctionID      string `json:"auctionID"`
+	ResourceID     string `json:"resourceID"`
+	Deadline       int64  `json:"deadline"`
+	OverdueSeconds int64  `json:"overdueSeconds"`
+}
+
+type EndingAuction struct {
+	AuctionID        string `json:"auctionID"`
+	ResourceID       string `json:"resourceID"`
+	Deadline         int64  `json:"deadline"`
+	RemainingSeconds int64  `json:"remainingSeconds"`
+}
+type AuctionOutcome struct {
+	AuctionID   string `json:"auctionID"`
+	ResourceID  string `json:"resourceID"`
+	WinnerID    string `json:"winnerID"`
+	WinnerPrice int64  `json:"winnerPriceMinor"`
+}
+
+type FailedAuction struct {
+	AuctionID  string `json:"auctionID"`
+	ResourceID string `json:"resourceID"`
+	Reason     string `json:"reason"`
+}
+
+type EndExpiredAuctionsResult struct {
+	Ended  []AuctionOutcome `json:"ended"`
+	Failed []FailedAuction  `json:"failed"`
+}
+
+// ResourceDescriptor is one entry of a SubmitEnergyResources batch. Amounts are in major units,
+// as for SubmitEnergyResource, and carbonIntensity may be left out.
+type ResourceDescriptor struct {
+	ResourceID      string          `json:"resourceID"`
+	Volume          float64         `json:"volume"`
+	Price           float64         `json:"price"`
+	Type            string          `json:"type"`
+	Region          string          `json:"region"`
+	CarbonIntensity *float64        `json:"carbonIntensity"`
+	Metadata        json.RawMessage `json:"metadata"`
+}
+
+type FailedResource struct {
+	ResourceID string `json:"resourceID"`
+	Reason     string `json:"reason"`
+}
+
+type SubmitResourcesResult struct {
+	Submitted []string         `json:"submitted"`
+	Failed    []FailedResource `json:"failed"`
+}
+
+type BidderStanding struct {
+	Bidder      string  `json:"bidder"`
+	TotalVolume float64 `json:"totalVolume"`
+	Wins        int     `json:"wins"`
+}
+
+type ResourceTypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type AuctionStats struct {
+	BidCount      int   `json:"bidCount"`
+	MinBid        int64 `json:"minBidMinor"`
+	MaxBid        int64 `json:"maxBidMinor"`
+	AvgBid        int64 `json:"avgBidMinor"`
+	UniqueBidders int   `json:"uniqueBidders"`
+	Withheld      bool  `json:"withheld"`
 }
 
+// Errors returned by the contract wrap one of these so callers can match them with errors.Is.
+var (
+	ErrInvalidArgument       = errors.New("invalid argument")
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrAlreadyExists         = errors.New("already exists")
+	ErrResourceNotFound      = errors.New("resource not found")
+	ErrResourceUnavailable   = errors.New("resource unavailable")
+	ErrResourceHasHistory    = errors.New("resource has auction history")
+	ErrAuctionNotFound       = errors.New("auction not found")
+	ErrAuctionActive         = errors.New("auction active")
+	ErrAuctionInactive       = errors.New("auction inactive")
+	ErrAuctionNotExpired     = errors.New("auction not expired")
+	ErrAuctionNotConvertible = errors.New("auction not convertible")
+	ErrSettlementNotFound    = errors.New("settlement not found")
+	ErrUnsoldRecordNotFound  = errors.New("unsold record not found")
+	ErrBidTooLow             = errors.New("bid too low")
+	ErrBidTooHigh            = errors.New("bid too high")
+	ErrResultTooLarge        = errors.New("result too large")
+	ErrResourceLocked        = errors.New("resource locked")
+)
+
+const contractVersion = "1.2.0"
+
+// ResourceLock is kept under the lock object type while a resource is being auctioned.
+type ResourceLock struct {
+	ResourceID  string `json:"resourceID"`
+	AuctionType string `json:"auctionType"`
+}
+
+const (
+	englishAuctionType     = "english"
+	secondPriceAuctionType = "second-price"
+)
+
 type EnergyAuctionContract struct {
 	contractapi.Contract
 }
 
+// GetContractInfo reports the optimized second-price auction, the only variant with a
+// per-auction reserve price.
+func (ac *EnergyAuctionContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*contractinfo.ContractInfo, error) {
+	return &contractinfo.ContractInfo{
+		AuctionType:     secondPriceAuctionType,
+		Version:         contractVersion,
+		SupportsReserve: true,
+		SupportsSealed:  true,
+	}, nil
+}
+
+const (
+	resourceObjectType   = "resource"
+	auctionObjectType    = "auction"
+	settlementObjectType = "settlement"
+	unsoldObjectType     = "unsold"
+	bundleObjectType     = "bundle"
+	configObjectType     = "config"
+	lockObjectType       = "lock"
+)
+
+const (
+	bundlePending = "pending"
+	bundleWon     = "won"
+	bundleLost    = "lost"
+)
+
+// ExportAllState reads the ledger in pages of exportPageSize and gives up once more than
+// maxExportRecords records have been read.
 const (
-	resourceObjectType = "resource"
-	auctionObjectType  = "auction"
+	exportPageSize   = 100
+	maxExportRecords = 10000
 )
 
-func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType string) error {
+const (
+	reasonReserveNotMet    = "reserve_not_met"
+	reasonMinBiddersNotMet = "min_bidders_not_met"
+)
+
+// metadataJSON is an optional JSON object of string values, such as a grid node ID or meter
+// serial, stored with the resource as is.
+func (ac *EnergyAuctionContract) SubmitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType, region, metadataJSON string) error {
+	return ac.submitEnergyResource(ctx, resourceID, energyVolume, energyPrice, resourceType, region, metadataJSON, unknownCarbonIntensity)
+}
+
+func (ac *EnergyAuctionContract) SubmitEnergyResourceWithCarbonIntensity(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType, region, metadataJSON string, carbonIntensity float64) error {
+	if carbonIntensity < 0 {
+		return fmt.Errorf("carbon intensity must not be negative: %w", ErrInvalidArgument)
+	}
+	return ac.submitEnergyResource(ctx, resourceID, energyVolume, energyPrice, resourceType, region, metadataJSON, carbonIntensity)
+}
+
+// SubmitEnergyResources lists several resources in one transaction. Entries that fail
+// validation, including IDs repeated within the batch, are reported and the rest are stored.
+func (ac *EnergyAuctionContract) SubmitEnergyResources(ctx contractapi.TransactionContextInterface, resourcesJSON string) (*SubmitResourcesResult, error) {
+	var descriptors []ResourceDescriptor
+	if err := json.Unmarshal([]byte(resourcesJSON), &descriptors); err != nil {
+		return nil, fmt.Errorf("resources must be a JSON array of resource descriptors: %w", ErrInvalidArgument)
+	}
+
+	result := SubmitResourcesResult{
+		Submitted: []string{},
+		Failed:    []FailedResource{},
+	}
+
+	// Writes made earlier in this transaction are not visible to GetState, so repeated IDs
+	// have to be caught here.
+	seen := map[string]bool{}
+	for _, descriptor := range descriptors {
+		if seen[descriptor.ResourceID] {
+			result.Failed = append(result.Failed, FailedResource{ResourceID: descriptor.ResourceID, Reason: "duplicate resource ID in batch"})
+			continue
+		}
+		seen[descriptor.ResourceID] = true
+
+		carbonIntensity := float64(unknownCarbonIntensity)
+		if descriptor.CarbonIntensity != nil {
+			if *descriptor.CarbonIntensity < 0 {
+				result.Failed = append(result.Failed, FailedResource{ResourceID: descriptor.ResourceID, Reason: "carbon intensity must not be negative"})
+				continue
+			}
+			carbonIntensity = *descriptor.CarbonIntensity
+		}
+
+		err := ac.submitEnergyResource(ctx, descriptor.ResourceID, descriptor.Volume, descriptor.Price, descriptor.Type, descriptor.Region, string(descriptor.Metadata), carbonIntensity)
+		if err != nil {
+			result.Failed = append(result.Failed, FailedResource{ResourceID: descriptor.ResourceID, Reason: err.Error()})
+			continue
+		}
+		result.Submitted = append(result.Submitted, descriptor.ResourceID)
+	}
+
+	return &result, nil
+}
+
+func (ac *EnergyAuctionContract) submitEnergyResource(ctx contractapi.TransactionContextInterface, resourceID string, energyVolume, energyPrice float64, resourceType, region, metadataJSON string, carbonIntensity float64) error {
+	if energyVolume <= 0 {
+		return fmt.Errorf("energy volume must be greater than zero: %w", ErrInvalidArgument)
+	}
+
+	if energyPrice < 0 {
+		return fmt.Errorf("energy price must not be negative: %w", ErrInvalidArgument)
+	}
+
+	if resourceType == "" {
+		return fmt.Errorf("resource type must not be empty: %w", ErrInvalidArgument)
+	}
+
+	allowedTypes, err := ac.fetchAllowedTypes(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(allowedTypes, resourceType) {
+		return fmt.Errorf("resource type %s is not one of %s: %w", resourceType, strings.Join(allowedTypes, ", "), ErrInvalidArgument)
+	}
+
+	// encoding/json writes map keys in sorted order, so the stored resource is identical on every peer.
+	var metadata map[string]string
+	if metadataJSON != "" {
+		if err := json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
+			return fmt.Errorf("metadata must be a JSON object of string values: %w", ErrInvalidArgument)
+		}
+	}
+
 	if err := ac.checkResourceExists(ctx, resourceID); err != nil {
 		return err
 	}
 
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
 	resource := EnergyResource{
-		Volume:        energyVolume,
-		Price:         energyPrice,
-		Type:          resourceType,
-		IsAvailable:   true,
-		AuctionStatus: false,
+		Volume:          energyVolume,
+		Price:           ac.toMinorUnits(energyPrice),
+		Type:            resourceType,
+		IsAvailable:     true,
+		AuctionStatus:   false,
+		Owner:           clientID,
+		CarbonIntensity: carbonIntensity,
+		Region:          region,
+		Metadata:        metadata,
 	}
 
 	return ac.storeResource(ctx, resourceID, resource)
 }
 
+func (ac *EnergyAuctionContract) SetAllowedTypes(ctx contractapi.TransactionContextInterface, types []string) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("client is not authorized to set allowed resource types: %w", ErrUnauthorized)
+	}
+
+	if len(types) == 0 {
+		return fmt.Errorf("at least one resource type must be allowed: %w", ErrInvalidArgument)
+	}
+
+	for _, resourceType := range types {
+		if resourceType == "" {
+			return fmt.Errorf("resource type must not be empty: %w", ErrInvalidArgument)
+		}
+	}
+
+	allowedTypes := slices.Clone(types)
+	slices.Sort(allowedTypes)
+
+	return ac.storeObject(ctx, ac.createCompositeKey(ctx, configObjectType, "allowedTypes"), slices.Compact(allowedTypes))
+}
+
+func (ac *EnergyAuctionContract) GetAllowedTypes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return ac.fetchAllowedTypes(ctx)
+}
+
 func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
 	fetchedResource, err := ac.fetchResource(ctx, resourceID)
 	if err != nil {
@@ -67,238 +493,2019 @@ func (ac *EnergyAuctionContract) GetResource(ctx contractapi.TransactionContextI
 	return ac.marshalToString(fetchedResource)
 }
 
-func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContextInterface) ([]EnergyResource, error) {
-	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+func (ac *EnergyAuctionContract) DeleteResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("resource with ID %s is currently in an auction: %w", resourceID, ErrAuctionActive)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientID != resource.Owner {
+		return fmt.Errorf("only the owner of resource with ID %s can delete it: %w", resourceID, ErrUnauthorized)
+	}
+
+	// Auctions and settlements refer back to the resource, so it stays once it has been auctioned.
+	auctions, err := ac.fetchAuctionsForResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	if len(auctions) > 0 {
+		return fmt.Errorf("resource with ID %s has %d auction(s) on record: %w", resourceID, len(auctions), ErrResourceHasHistory)
+	}
+
+	settlements, err := ac.fetchSettlementsForResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	if len(settlements) > 0 {
+		return fmt.Errorf("resource with ID %s has %d settlement(s) on record: %w", resourceID, len(settlements), ErrResourceHasHistory)
+	}
+
+	// RelistResource clears unsold auctions but keeps their unsold records.
+	unsoldRecords, err := ctx.GetStub().GetStateByPartialCompositeKey(unsoldObjectType, []string{resourceID})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve unsold records: %v", err)
+	}
+	defer unsoldRecords.Close()
+	if unsoldRecords.HasNext() {
+		return fmt.Errorf("resource with ID %s has unsold auctions on record: %w", resourceID, ErrResourceHasHistory)
+	}
+
+	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
+	return ctx.GetStub().DelState(resourceKey)
+}
+
+func (ac *EnergyAuctionContract) TransferResource(ctx contractapi.TransactionContextInterface, resourceID, newOwner string) error {
+	if newOwner == "" {
+		return fmt.Errorf("new owner must not be empty: %w", ErrInvalidArgument)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("resource with ID %s is currently in an auction: %w", resourceID, ErrAuctionActive)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientID != resource.Owner {
+		return fmt.Errorf("only the owner of resource with ID %s can transfer it: %w", resourceID, ErrUnauthorized)
+	}
+
+	resource.Owner = newOwner
+	if err := ac.storeResource(ctx, resourceID, *resource); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(OwnershipTransferredEvent{ResourceID: resourceID, PreviousOwner: clientID, NewOwner: newOwner})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return ctx.GetStub().SetEvent("OwnershipTransferred", eventJSON)
+}
+
+// RelistResource clears the auctions a resource ended without a winner so it can be auctioned
+// again. Their outcome stays on record as unsold records. Only the resource owner or an admin
+// may relist it.
+func (ac *EnergyAuctionContract) RelistResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "relist it"); err != nil {
+		return err
+	}
+
+	auctions, err := ac.fetchAuctionsForResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	for _, auction := range auctions {
+		if auction.IsActive {
+			return fmt.Errorf("auction %s for resource with ID %s is still active: %w", auction.AuctionID, resourceID, ErrAuctionActive)
+		}
+	}
+
+	if !resource.IsAvailable {
+		return fmt.Errorf("resource with ID %s is not available: %w", resourceID, ErrResourceUnavailable)
+	}
+
+	for _, auction := range auctions {
+		if auction.WinnerID != "" {
+			continue
+		}
+		if err := ctx.GetStub().DelState(ac.createCompositeKey(ctx, auctionObjectType, resourceID, auction.AuctionID)); err != nil {
+			return fmt.Errorf("failed to delete auction %s: %v", auction.AuctionID, err)
+		}
+	}
+
+	resource.AuctionStatus = false
+
+	return ac.storeResource(ctx, resourceID, *resource)
+}
+
+func (ac *EnergyAuctionContract) GetResourceCount(ctx contractapi.TransactionContextInterface) (int, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	count := 0
+	for results.HasNext() {
+		if _, err := results.Next(); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// GetResourceTypes returns each distinct resource type with the number of resources of that
+// type, sorted by type name.
+func (ac *EnergyAuctionContract) GetResourceTypes(ctx contractapi.TransactionContextInterface) ([]ResourceTypeCount, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	counts := map[string]int{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		if err := json.Unmarshal(next.Value, &resource); err != nil {
+			return nil, err
+		}
+		counts[resource.Type]++
+	}
+
+	types := make([]ResourceTypeCount, 0, len(counts))
+	for resourceType, count := range counts {
+		types = append(types, ResourceTypeCount{Type: resourceType, Count: count})
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Type < types[j].Type
+	})
+
+	return types, nil
+}
+
+func (ac *EnergyAuctionContract) GetMeritOrder(ctx contractapi.TransactionContextInterface) ([]EnergyResource, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	var resources []EnergyResource
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		err = json.Unmarshal(next.Value, &resource)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return resources, nil
+}
+
+func (ac *EnergyAuctionContract) GetMeritOrderByType(ctx contractapi.TransactionContextInterface, resourceType string) ([]EnergyResource, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	resources := []EnergyResource{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		err = json.Unmarshal(next.Value, &resource)
+		if err != nil {
+			return nil, err
+		}
+
+		if resource.Type == resourceType {
+			resources = append(resources, resource)
+		}
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return resources, nil
+}
+
+// GetMeritOrderFiltered matches any type when resourceType is empty; a minVolume of zero
+// imposes no volume constraint.
+func (ac *EnergyAuctionContract) GetMeritOrderFiltered(ctx contractapi.TransactionContextInterface, resourceType string, minVolume float64) ([]EnergyResource, error) {
+	if minVolume < 0 {
+		return nil, fmt.Errorf("minimum volume must not be negative: %w", ErrInvalidArgument)
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	resources := []EnergyResource{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		err = json.Unmarshal(next.Value, &resource)
+		if err != nil {
+			return nil, err
+		}
+
+		if !resource.IsAvailable || resource.Volume < minVolume {
+			continue
+		}
+		if resourceType != "" && resource.Type != resourceType {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return resources, nil
+}
+
+func (ac *EnergyAuctionContract) GetAvailableResources(ctx contractapi.TransactionContextInterface) ([]ResourceWithID, error) {
+	return ac.fetchResourcesWithIDs(ctx, true)
+}
+
+// GetAllResourcesWithIDs lists every resource with the ID it is stored under, cheapest first,
+// so entries from the merit order can be bid on directly.
+func (ac *EnergyAuctionContract) GetAllResourcesWithIDs(ctx contractapi.TransactionContextInterface) ([]ResourceWithID, error) {
+	return ac.fetchResourcesWithIDs(ctx, false)
+}
+
+func (ac *EnergyAuctionContract) GetMeritOrderByRegion(ctx contractapi.TransactionContextInterface, region string) ([]EnergyResource, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	resources := []EnergyResource{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		err = json.Unmarshal(next.Value, &resource)
+		if err != nil {
+			return nil, err
+		}
+
+		if resource.IsAvailable && resource.Region == region {
+			resources = append(resources, resource)
+		}
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return resources, nil
+}
+
+func (ac *EnergyAuctionContract) GetGreenMeritOrder(ctx contractapi.TransactionContextInterface, maxIntensity float64) ([]EnergyResource, error) {
+	if maxIntensity < 0 {
+		return nil, fmt.Errorf("maximum carbon intensity must not be negative: %w", ErrInvalidArgument)
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	resources := []EnergyResource{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		err = json.Unmarshal(next.Value, &resource)
+		if err != nil {
+			return nil, err
+		}
+
+		if !resource.IsAvailable || resource.CarbonIntensity < 0 || resource.CarbonIntensity > maxIntensity {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Price < resources[j].Price
+	})
+
+	return resources, nil
+}
+
+func (ac *EnergyAuctionContract) QueryResourcesByPriceRange(ctx contractapi.TransactionContextInterface, minPrice, maxPrice float64) ([]EnergyResource, error) {
+	if minPrice > maxPrice {
+		return nil, fmt.Errorf("minimum price must not exceed maximum price: %w", ErrInvalidArgument)
+	}
+
+	// Resources written before prices were kept in minor units still hold a major-unit "price".
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"$or": []interface{}{
+				map[string]interface{}{
+					"priceMinor": map[string]interface{}{
+						"$gte": ac.toMinorUnits(minPrice),
+						"$lte": ac.toMinorUnits(maxPrice),
+					},
+				},
+				map[string]interface{}{
+					"price": map[string]interface{}{
+						"$gte": minPrice,
+						"$lte": maxPrice,
+					},
+				},
+			},
+			"isAvailable": true,
+		},
+	}
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %v", err)
+	}
+
+	results, err := ctx.GetStub().GetQueryResult(string(queryJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute rich query, CouchDB is required as the state database: %v", err)
+	}
+	defer results.Close()
+
+	resources := []EnergyResource{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		objectType, _, err := ctx.GetStub().SplitCompositeKey(next.Key)
+		if err != nil || objectType != resourceObjectType {
+			continue
+		}
+
+		var resource EnergyResource
+		if err := json.Unmarshal(next.Value, &resource); err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+func (ac *EnergyAuctionContract) GetMeritOrderPaginated(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) ([]EnergyResource, string, error) {
+	results, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(resourceObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	var resources []EnergyResource
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, "", err
+		}
+
+		_, splitKey, err := ctx.GetStub().SplitCompositeKey(next.Key)
+		if err != nil {
+			return nil, "", err
+		}
+		resourceID := splitKey[len(splitKey)-1]
+
+		resource, err := ac.fetchResource(ctx, resourceID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resources = append(resources, *resource)
+	}
+
+	return resources, metadata.Bookmark, nil
+}
+
+// ExportAllState dumps every record of the contract grouped by object type, for backups and
+// migrations. It must be evaluated as a query because it reads with pagination.
+func (ac *EnergyAuctionContract) ExportAllState(ctx contractapi.TransactionContextInterface) (*StateExport, error) {
+	export := StateExport{
+		Resources:     []ResourceWithID{},
+		Auctions:      []EnergyAuction{},
+		Settlements:   []Settlement{},
+		UnsoldRecords: []UnsoldRecord{},
+		Bundles:       []BundleBid{},
+		AllowedTypes:  []string{},
+		LockPeers:     []string{},
+		Locks:         []ResourceLock{},
+	}
+
+	objectTypes := []string{resourceObjectType, auctionObjectType, settlementObjectType, unsoldObjectType, bundleObjectType, configObjectType, lockObjectType}
+	recordCount := 0
+	for _, objectType := range objectTypes {
+		bookmark := ""
+		for {
+			results, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, []string{}, exportPageSize, bookmark)
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve %s records: %v", objectType, err)
+			}
+
+			for results.HasNext() {
+				next, err := results.Next()
+				if err != nil {
+					results.Close()
+					return nil, err
+				}
+
+				recordCount++
+				if recordCount > maxExportRecords {
+					results.Close()
+					return nil, fmt.Errorf("ledger holds more than %d records: %w", maxExportRecords, ErrResultTooLarge)
+				}
+
+				if err := ac.addToExport(ctx, &export, objectType, next.Key, next.Value); err != nil {
+					results.Close()
+					return nil, err
+				}
+			}
+			results.Close()
+
+			if metadata.FetchedRecordsCount < exportPageSize || metadata.Bookmark == "" {
+				break
+			}
+			bookmark = metadata.Bookmark
+		}
+	}
+
+	return &export, nil
+}
+
+// LockResource reserves a resource for an auction type. Only the resource owner or an admin
+// may take the lock.
+func (ac *EnergyAuctionContract) LockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	if auctionType != englishAuctionType && auctionType != secondPriceAuctionType {
+		return fmt.Errorf("auction type must be %q or %q: %w", englishAuctionType, secondPriceAuctionType, ErrInvalidArgument)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "manage its lock"); err != nil {
+		return err
+	}
+
+	return ac.lockResource(ctx, resourceID, auctionType)
+}
+
+// UnlockResource releases the lock of a resource that is not in an auction. Only the resource
+// owner or an admin may release it; a lock left behind by a deleted resource needs an admin.
+func (ac *EnergyAuctionContract) UnlockResource(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedResource, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, resourceObjectType, resourceID))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve resource: %v", err)
+	}
+
+	var resource *EnergyResource
+	if fetchedResource != nil {
+		resource = &EnergyResource{}
+		if err := json.Unmarshal(fetchedResource, resource); err != nil {
+			return fmt.Errorf("failed to unmarshal resource: %v", err)
+		}
+	}
+
+	if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "manage its lock"); err != nil {
+		return err
+	}
+
+	if resource != nil && resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is still active: %w", resourceID, ErrAuctionActive)
+	}
+
+	return ac.releaseLock(ctx, resourceID)
+}
+
+// GetResourceLock returns the auction type holding the lock of a resource, or an empty string
+// when it is not locked. Lock peers call it through InvokeChaincode.
+func (ac *EnergyAuctionContract) GetResourceLock(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
+	lock, err := ac.fetchLock(ctx, resourceID)
+	if err != nil {
+		return "", err
+	}
+	if lock == nil {
+		return "", nil
+	}
+	return lock.AuctionType, nil
+}
+
+// SetLockPeers names the chaincodes on this channel whose resource locks must be free before
+// an auction starts here. An empty list stops consulting other chaincodes.
+func (ac *EnergyAuctionContract) SetLockPeers(ctx contractapi.TransactionContextInterface, chaincodeNames []string) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("client is not authorized to set lock peers: %w", ErrUnauthorized)
+	}
+
+	for _, chaincodeName := range chaincodeNames {
+		if chaincodeName == "" {
+			return fmt.Errorf("chaincode name must not be empty: %w", ErrInvalidArgument)
+		}
+	}
+
+	return ac.storeObject(ctx, ac.createCompositeKey(ctx, configObjectType, "lockPeers"), chaincodeNames)
+}
+
+// An auction drawing fewer than minBidders distinct bidders is void; zero sets no minimum.
+// The winner price is rounded to priceDecimals decimal places, at most two.
+func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID string, duration int64, reservePrice, maxBidPerBidder float64, minBidders, priceDecimals int) error {
+	if auctionID == "" {
+		return fmt.Errorf("auction ID must not be empty: %w", ErrInvalidArgument)
+	}
+
+	if reservePrice < 0 {
+		return fmt.Errorf("reserve price must not be negative: %w", ErrInvalidArgument)
+	}
+
+	// A zero cap leaves bids uncapped.
+	if maxBidPerBidder < 0 {
+		return fmt.Errorf("maximum bid per bidder must not be negative: %w", ErrInvalidArgument)
+	}
+
+	if minBidders < 0 {
+		return fmt.Errorf("minimum number of bidders must not be negative: %w", ErrInvalidArgument)
+	}
+
+	if priceDecimals < 0 || priceDecimals > maxPriceDecimals {
+		return fmt.Errorf("price decimals must be between 0 and %d: %w", maxPriceDecimals, ErrInvalidArgument)
+	}
+
+	return ac.startAuction(ctx, EnergyAuction{
+		AuctionID:       auctionID,
+		ResourceID:      resourceID,
+		ReservePrice:    ac.toMinorUnits(reservePrice),
+		MaxBidPerBidder: ac.toMinorUnits(maxBidPerBidder),
+		MinBidders:      minBidders,
+		PriceDecimals:   priceDecimals,
+	}, duration, 0)
+}
+
+// StartAuctionFromLastSettlement starts an auction whose bids must beat the price of the
+// resource's most recent settlement, or the resource price if it has never been settled.
+// A sold resource is listed again with the volume its last settlement left unallocated, which
+// only its owner or an admin may do.
+func (ac *EnergyAuctionContract) StartAuctionFromLastSettlement(ctx contractapi.TransactionContextInterface, resourceID, auctionID string, duration int64) error {
+	if auctionID == "" {
+		return fmt.Errorf("auction ID must not be empty: %w", ErrInvalidArgument)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	settlements, err := ac.fetchSettlementsForResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	bidFloor := resource.Price
+	var lastSettlement *Settlement
+	for i := range settlements {
+		if lastSettlement == nil || settlements[i].Timestamp > lastSettlement.Timestamp {
+			lastSettlement = &settlements[i]
+			bidFloor = lastSettlement.Price
+		}
+	}
+
+	relistVolume := 0.0
+	if !resource.IsAvailable && lastSettlement != nil {
+		if err := ac.checkOwnerOrAdmin(ctx, resourceID, resource, "relist it"); err != nil {
+			return err
+		}
+
+		// Combinatorial settlements carry no allocations and always take the whole volume.
+		soldVolume := lastSettlement.Volume
+		if len(lastSettlement.Allocations) > 0 {
+			soldVolume = 0
+			for _, allocation := range lastSettlement.Allocations {
+				soldVolume += allocation.Volume
+			}
+		}
+
+		relistVolume = resource.Volume - soldVolume
+		if relistVolume <= 0 {
+			return fmt.Errorf("resource with ID %s was sold in full by auction %s: %w", resourceID, lastSettlement.AuctionID, ErrResourceUnavailable)
+		}
+	}
+
+	return ac.startAuction(ctx, EnergyAuction{
+		AuctionID:     auctionID,
+		ResourceID:    resourceID,
+		BidFloor:      bidFloor,
+		PriceDecimals: maxPriceDecimals,
+	}, duration, relistVolume)
+}
+
+// startAuction opens the auction described by the given template, which carries the
+// auction's settings, for duration seconds. A positive relistVolume lists a resource an earlier
+// auction sold again, with that much volume.
+func (ac *EnergyAuctionContract) startAuction(ctx contractapi.TransactionContextInterface, auction EnergyAuction, duration int64, relistVolume float64) error {
+	resourceID, auctionID := auction.ResourceID, auction.AuctionID
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is already active: %w", resourceID, ErrAuctionActive)
+	}
+
+	if !resource.IsAvailable && relistVolume <= 0 {
+		return fmt.Errorf("resource with ID %s is not available: %w", resourceID, ErrResourceUnavailable)
+	}
+
+	if err := ac.lockResource(ctx, resourceID, secondPriceAuctionType); err != nil {
+		return err
+	}
+
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID, auctionID)
+	existingAuction, err := ctx.GetStub().GetState(auctionKey)
+	if err != nil {
+		return fmt.Errorf("failed to interact with world state: %v", err)
+	}
+	if existingAuction != nil {
+		return fmt.Errorf("auction %s already exists for resource with ID %s: %w", auctionID, resourceID, ErrAlreadyExists)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	auction.SchemaVersion = auctionSchemaVersion
+	auction.Deadline = currentTime + duration
+	auction.Bids = []Bid{}
+	auction.IsActive = true
+	resource.AuctionStatus = true
+	if relistVolume > 0 {
+		resource.IsAvailable = true
+		resource.Volume = relistVolume
+	}
+
+	updates := make(map[string][]byte)
+
+	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
+	resourceJSON, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %v", err)
+	}
+	updates[resourceKey] = resourceJSON
+
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction: %v", err)
+	}
+	updates[auctionKey] = auctionJSON
+
+	return ac.batchStore(ctx, updates)
+}
+
+// ConvertToOpenAuction restarts a resource whose reserve auction ended without a sale as an
+// open auction with no reserve. Bids in the open auction are visible while it runs.
+func (ac *EnergyAuctionContract) ConvertToOpenAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID, openAuctionID string, duration int64) error {
+	if openAuctionID == "" {
+		return fmt.Errorf("auction ID must not be empty: %w", ErrInvalidArgument)
+	}
+
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if auction.IsActive {
+		return fmt.Errorf("auction %s for resource with ID %s is still active: %w", auctionID, resourceID, ErrAuctionActive)
+	}
+
+	if auction.ReservePrice == 0 || auction.WinnerID != "" {
+		return fmt.Errorf("auction %s for resource with ID %s did not end with an unmet reserve: %w", auctionID, resourceID, ErrAuctionNotConvertible)
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	if resource.AuctionStatus {
+		return fmt.Errorf("auction for resource with ID %s is already active: %w", resourceID, ErrAuctionActive)
+	}
+
+	if !resource.IsAvailable {
+		return fmt.Errorf("resource with ID %s is not available: %w", resourceID, ErrResourceUnavailable)
+	}
+
+	if err := ac.lockResource(ctx, resourceID, secondPriceAuctionType); err != nil {
+		return err
+	}
+
+	openAuctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID, openAuctionID)
+	existingAuction, err := ctx.GetStub().GetState(openAuctionKey)
+	if err != nil {
+		return fmt.Errorf("failed to interact with world state: %v", err)
+	}
+	if existingAuction != nil {
+		return fmt.Errorf("auction %s already exists for resource with ID %s: %w", openAuctionID, resourceID, ErrAlreadyExists)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	openAuction := EnergyAuction{
+		SchemaVersion:   auctionSchemaVersion,
+		AuctionID:       openAuctionID,
+		ResourceID:      resourceID,
+		Deadline:        currentTime + duration,
+		Bids:            []Bid{},
+		MaxBidPerBidder: auction.MaxBidPerBidder,
+		MinBidders:      auction.MinBidders,
+		PriceDecimals:   auction.PriceDecimals,
+		IsOpen:          true,
+		IsActive:        true,
+	}
+	resource.AuctionStatus = true
+	auction.Bids = []Bid{}
+
+	updates := make(map[string][]byte)
+
+	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
+	resourceJSON, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("failed to marshal resource: %v", err)
+	}
+	updates[resourceKey] = resourceJSON
+
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID, auctionID)
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction: %v", err)
+	}
+	updates[auctionKey] = auctionJSON
+
+	openAuctionJSON, err := json.Marshal(openAuction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction: %v", err)
+	}
+	updates[openAuctionKey] = openAuctionJSON
+
+	return ac.batchStore(ctx, updates)
+}
+
+func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (string, error) {
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return "", err
+	}
+
+	// Sealed bids stay hidden until the auction has been finalized, as in GetAuctionStats.
+	if !auction.IsOpen && auction.IsActive {
+		auction.Bids = []Bid{}
+	}
+
+	return ac.marshalToString(auction)
+}
+
+func (ac *EnergyAuctionContract) GetAuctionStats(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (*AuctionStats, error) {
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := AuctionStats{BidCount: len(auction.Bids)}
+
+	// Price statistics would leak sealed bids, so only the count is shown until the auction
+	// has ended. An expired auction stays sealed until EndAuction closes it.
+	if !auction.IsOpen && auction.IsActive {
+		stats.Withheld = true
+		return &stats, nil
+	}
+
+	if len(auction.Bids) == 0 {
+		return &stats, nil
+	}
+
+	bidders := make(map[string]bool)
+	total := int64(0)
+	stats.MinBid = auction.Bids[0].BidPrice
+	for _, bid := range auction.Bids {
+		stats.MinBid = min(stats.MinBid, bid.BidPrice)
+		stats.MaxBid = max(stats.MaxBid, bid.BidPrice)
+		total += bid.BidPrice
+		bidders[bid.Bidder] = true
+	}
+	stats.AvgBid = int64(math.Round(float64(total) / float64(len(auction.Bids))))
+	stats.UniqueBidders = len(bidders)
+
+	return &stats, nil
+}
+
+// GetMedianBid returns the median bid price of a closed auction in minor units. With an even
+// number of bids the two middle prices are averaged and rounded like AvgBid.
+func (ac *EnergyAuctionContract) GetMedianBid(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (int64, error) {
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return 0, err
+	}
+
+	if auction.IsActive {
+		return 0, fmt.Errorf("auction %s for resource with ID %s is still active: %w", auctionID, resourceID, ErrAuctionActive)
+	}
+
+	if len(auction.Bids) == 0 {
+		return 0, fmt.Errorf("auction %s for resource with ID %s has no bids: %w", auctionID, resourceID, ErrInvalidArgument)
+	}
+
+	prices := make([]int64, 0, len(auction.Bids))
+	for _, bid := range auction.Bids {
+		prices = append(prices, bid.BidPrice)
+	}
+	slices.Sort(prices)
+
+	middle := len(prices) / 2
+	if len(prices)%2 == 1 {
+		return prices[middle], nil
+	}
+	return int64(math.Round(float64(prices[middle-1]+prices[middle]) / 2)), nil
+}
+
+func (ac *EnergyAuctionContract) GetAuctionsForResource(ctx contractapi.TransactionContextInterface, resourceID string) ([]EnergyAuction, error) {
+	auctions, err := ac.fetchAuctionsForResource(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range auctions {
+		if !auctions[i].IsOpen && auctions[i].IsActive {
+			auctions[i].Bids = []Bid{}
+		}
+	}
+
+	sort.SliceStable(auctions, func(i, j int) bool {
+		return auctions[i].Deadline < auctions[j].Deadline
+	})
+
+	return auctions, nil
+}
+
+func (ac *EnergyAuctionContract) GetActiveAuctions(ctx contractapi.TransactionContextInterface) ([]EnergyAuction, error) {
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	auctions := []EnergyAuction{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		auction, err := ac.decodeAuction(ctx, next.Key, next.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !auction.IsActive || auction.Deadline < currentTime {
+			continue
+		}
+
+		if !auction.IsOpen {
+			auction.Bids = []Bid{}
+		}
+		auctions = append(auctions, *auction)
+	}
+
+	sort.Slice(auctions, func(i, j int) bool {
+		return auctions[i].Deadline < auctions[j].Deadline
+	})
+
+	return auctions, nil
+}
+
+func (ac *EnergyAuctionContract) GetActiveAuctionsByResourceType(ctx contractapi.TransactionContextInterface, resourceType string) ([]EnergyAuction, error) {
+	activeAuctions, err := ac.GetActiveAuctions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Several auctions may share a resource, so each resource type is looked up once.
+	resourceTypes := map[string]string{}
+	auctions := []EnergyAuction{}
+	for _, auction := range activeAuctions {
+		if _, ok := resourceTypes[auction.ResourceID]; !ok {
+			resource, err := ac.fetchResource(ctx, auction.ResourceID)
+			if err != nil {
+				return nil, err
+			}
+			resourceTypes[auction.ResourceID] = resource.Type
+		}
+
+		if resourceTypes[auction.ResourceID] == resourceType {
+			auctions = append(auctions, auction)
+		}
+	}
+
+	return auctions, nil
+}
+
+// GetActiveAuctionCount has to decode each auction, since activity depends on both the status
+// flag and the deadline.
+func (ac *EnergyAuctionContract) GetActiveAuctionCount(ctx contractapi.TransactionContextInterface) (int, error) {
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	count := 0
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		auction, err := ac.decodeAuction(ctx, next.Key, next.Value)
+		if err != nil {
+			return 0, err
+		}
+
+		if auction.IsActive && auction.Deadline >= currentTime {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetMyWins lists the closed auctions in which the caller won volume, most recent deadline
+// first. Under uniform pricing every winner pays WinnerPrice per unit.
+func (ac *EnergyAuctionContract) GetMyWins(ctx contractapi.TransactionContextInterface) ([]WonAuction, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	wins := []WonAuction{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		auction, err := ac.decodeAuction(ctx, next.Key, next.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if auction.IsActive || auction.WinnerID == "" {
+			continue
+		}
+
+		resource, err := ac.fetchResource(ctx, auction.ResourceID)
+		if errors.Is(err, ErrResourceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Auctions settled as part of a bundle have no allocations and give the winner everything.
+		allocated := 0.0
+		if len(auction.Allocations) == 0 && auction.WinnerID == clientID {
+			allocated = resource.Volume
+		}
+		for _, allocation := range auction.Allocations {
+			if allocation.Bidder == clientID {
+				allocated += allocation.Volume
+			}
+		}
+		if allocated == 0 {
+			continue
+		}
+
+		wins = append(wins, WonAuction{
+			AuctionID:       auction.AuctionID,
+			ResourceID:      auction.ResourceID,
+			WinnerPrice:     auction.WinnerPrice,
+			Volume:          resource.Volume,
+			AllocatedVolume: allocated,
+			Type:            resource.Type,
+			Deadline:        auction.Deadline,
+		})
+	}
+
+	sort.SliceStable(wins, func(i, j int) bool {
+		return wins[i].Deadline > wins[j].Deadline
+	})
+
+	return wins, nil
+}
+
+func (ac *EnergyAuctionContract) GetExpiredAuctions(ctx contractapi.TransactionContextInterface) ([]ExpiredAuction, error) {
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	expired := []ExpiredAuction{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		auction, err := ac.decodeAuction(ctx, next.Key, next.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !auction.IsActive || auction.Deadline >= currentTime {
+			continue
+		}
+
+		expired = append(expired, ExpiredAuction{
+			AuctionID:      auction.AuctionID,
+			ResourceID:     auction.ResourceID,
+			Deadline:       auction.Deadline,
+			OverdueSeconds: currentTime - auction.Deadline,
+		})
+	}
+
+	return expired, nil
+}
+
+// GetAuctionsEndingSoon lists active auctions whose deadline falls within windowSeconds of the
+// current block time, soonest first. Auctions already past their deadline are left out.
+func (ac *EnergyAuctionContract) GetAuctionsEndingSoon(ctx contractapi.TransactionContextInterface, windowSeconds int64) ([]EndingAuction, error) {
+	if windowSeconds <= 0 {
+		return nil, fmt.Errorf("window must be greater than zero: %w", ErrInvalidArgument)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	ending := []EndingAuction{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		auction, err := ac.decodeAuction(ctx, next.Key, next.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !auction.IsActive || auction.Deadline < currentTime || auction.Deadline-currentTime > windowSeconds {
+			continue
+		}
+
+		ending = append(ending, EndingAuction{
+			AuctionID:        auction.AuctionID,
+			ResourceID:       auction.ResourceID,
+			Deadline:         auction.Deadline,
+			RemainingSeconds: auction.Deadline - currentTime,
+		})
+	}
+
+	sort.SliceStable(ending, func(i, j int) bool {
+		return ending[i].Deadline < ending[j].Deadline
+	})
+
+	return ending, nil
+}
+
+// bidAmount is a per-unit price for requestedVolume, which may be any part of the resource.
+func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID, auctionID string, bidAmount, requestedVolume float64) error {
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return err
+	}
+
+	resource, err := ac.fetchResource(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+
+	bidUnits := ac.toMinorUnits(bidAmount)
+
+	if bidUnits <= resource.Price {
+		return fmt.Errorf("bid amount must be higher than resource price: %w", ErrBidTooLow)
+	}
+
+	if bidUnits <= auction.BidFloor {
+		return fmt.Errorf("bid amount must be higher than the auction's bid floor of %.2f: %w", ac.fromMinorUnits(auction.BidFloor), ErrBidTooLow)
+	}
+
+	if auction.MaxBidPerBidder > 0 && bidUnits > auction.MaxBidPerBidder {
+		return fmt.Errorf("bid amount must not exceed the per-bidder cap of %.2f: %w", ac.fromMinorUnits(auction.MaxBidPerBidder), ErrBidTooHigh)
+	}
+
+	if requestedVolume <= 0 || requestedVolume > resource.Volume {
+		return fmt.Errorf("requested volume must be greater than zero and at most the resource volume of %f: %w", resource.Volume, ErrInvalidArgument)
+	}
+
+	if !auction.IsActive {
+		return fmt.Errorf("auction for resource with ID %s is not active: %w", resourceID, ErrAuctionInactive)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	if auction.Deadline < currentTime {
+		return ac.EndAuction(ctx, resourceID, auctionID)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	bid := Bid{
+		BidID:           fmt.Sprintf("%s:%s:%s:%d", resourceID, auctionID, clientID, currentTime),
+		ResourceID:      resourceID,
+		Bidder:          clientID,
+		BidPrice:        bidUnits,
+		RequestedVolume: requestedVolume,
+		Timestamp:       currentTime,
+	}
+
+	for i, existingBid := range auction.Bids {
+		if existingBid.Bidder != clientID {
+			continue
+		}
+
+		if bidUnits == existingBid.BidPrice {
+			return fmt.Errorf("a bid at %.2f has already been placed by this bidder; each bidder holds a single bid that may only be raised: %w", ac.fromMinorUnits(bidUnits), ErrBidTooLow)
+		}
+
+		if bidUnits < existingBid.BidPrice {
+			return fmt.Errorf("bid amount must be higher than this bidder's existing bid of %.2f; each bidder holds a single bid that may only be raised: %w", ac.fromMinorUnits(existingBid.BidPrice), ErrBidTooLow)
+		}
+
+		auction.Bids[i] = bid
+		return ac.storeAuction(ctx, resourceID, auctionID, *auction)
+	}
+
+	auction.Bids = append(auction.Bids, bid)
+
+	return ac.storeAuction(ctx, resourceID, auctionID, *auction)
+}
+
+// EndAuction succeeds without changes when the auction was already finalized, so retried or
+// replayed calls do not fail.
+func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) error {
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return err
+	}
+
+	if !auction.IsActive && auction.FinalizedTxID != "" {
+		return nil
+	}
+
+	_, err = ac.finalizeAuction(ctx, resourceID, auctionID)
+	return err
+}
+
+func (ac *EnergyAuctionContract) EndExpiredAuctions(ctx contractapi.TransactionContextInterface) (*EndExpiredAuctionsResult, error) {
+	expired, err := ac.GetExpiredAuctions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := EndExpiredAuctionsResult{
+		Ended:  []AuctionOutcome{},
+		Failed: []FailedAuction{},
+	}
+	for _, expiredAuction := range expired {
+		auction, err := ac.finalizeAuction(ctx, expiredAuction.ResourceID, expiredAuction.AuctionID)
+		if err != nil {
+			result.Failed = append(result.Failed, FailedAuction{AuctionID: expiredAuction.AuctionID, ResourceID: expiredAuction.ResourceID, Reason: err.Error()})
+			continue
+		}
+
+		result.Ended = append(result.Ended, AuctionOutcome{
+			AuctionID:   auction.AuctionID,
+			ResourceID:  auction.ResourceID,
+			WinnerID:    auction.WinnerID,
+			WinnerPrice: auction.WinnerPrice,
+		})
+	}
+
+	return &result, nil
+}
+
+func (ac *EnergyAuctionContract) finalizeAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (*EnergyAuction, error) {
+	auction, err := ac.fetchAuction(ctx, resourceID, auctionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !auction.IsActive {
+		return nil, fmt.Errorf("auction for resource with ID %s is not active: %w", resourceID, ErrAuctionInactive)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if auction.Deadline > currentTime {
+		return nil, fmt.Errorf("auction for resource with ID %s has not yet expired: %w", resourceID, ErrAuctionNotExpired)
+	}
+
+	// Equal bids are ordered by earliest Timestamp, so the first bidder at the top price wins.
+	sort.Slice(auction.Bids, func(i, j int) bool {
+		if auction.Bids[i].BidPrice != auction.Bids[j].BidPrice {
+			return auction.Bids[i].BidPrice > auction.Bids[j].BidPrice
+		}
+		if auction.Bids[i].Timestamp != auction.Bids[j].Timestamp {
+			return auction.Bids[i].Timestamp < auction.Bids[j].Timestamp
+		}
+		return auction.Bids[i].BidID < auction.Bids[j].BidID
+	})
+
+	auction.IsActive = false
+	auction.FinalizedTxID = ctx.GetStub().GetTxID()
+
+	resource, err := ac.fetchResource(ctx, auction.ResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	resource.AuctionStatus = false
+
+	if err := ac.releaseLock(ctx, auction.ResourceID); err != nil {
+		return nil, err
+	}
+
+	highestBid := int64(0)
+	if len(auction.Bids) > 0 {
+		highestBid = auction.Bids[0].BidPrice
+	}
+
+	// An auction whose top bid falls short of the reserve ends unsold and the resource stays available.
+	reserveMet := auction.ReservePrice == 0 || highestBid >= auction.ReservePrice
+
+	// Too few bidders leave no meaningful second price, so the auction is void and nobody is charged.
+	bidders := map[string]bool{}
+	for _, bid := range auction.Bids {
+		bidders[bid.Bidder] = true
+	}
+	minBiddersMet := len(bidders) >= auction.MinBidders
+
+	if len(auction.Bids) > 0 && reserveMet && minBiddersMet {
+		resource.IsAvailable = false
+		floor := min(max(auction.ReservePrice, resource.Price, auction.BidFloor), highestBid)
+		auction.Allocations, auction.UnallocatedVolume, auction.WinnerPrice = ac.clearUniformPrice(auction.Bids, resource.Volume, floor)
+		auction.WinnerID = auction.Allocations[0].Bidder
+
+		// Bids are stored one per bidder in sorted order, so the last allocation holds the lowest winning bid.
+		lowestWinningBid := auction.Bids[len(auction.Allocations)-1].BidPrice
+		auction.WinnerPrice = ac.roundPrice(auction.WinnerPrice, auction.PriceDecimals, floor, lowestWinningBid)
+
+		// The runner-up steps in if the winner fails to settle: the best bid that received no
+		// allocation, paying the price the auction clears at once the winner's bid is dropped.
+		if runnerUp := len(auction.Allocations); runnerUp < len(auction.Bids) && auction.Bids[runnerUp].BidPrice >= floor {
+			_, _, runnerUpPrice := ac.clearUniformPrice(auction.Bids[1:], resource.Volume, floor)
+			auction.RunnerUpID = auction.Bids[runnerUp].Bidder
+			auction.RunnerUpPrice = ac.roundPrice(runnerUpPrice, auction.PriceDecimals, floor, auction.Bids[runnerUp].BidPrice)
+		}
+	}
+
+	updates := make(map[string][]byte)
+
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID, auctionID)
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal auction: %v", err)
+	}
+	updates[auctionKey] = auctionJSON
+
+	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
+	resourceJSON, err := json.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resource: %v", err)
+	}
+	updates[resourceKey] = resourceJSON
+
+	if auction.WinnerID != "" {
+		settlement := Settlement{
+			AuctionID:   auctionID,
+			ResourceID:  resourceID,
+			Buyer:       auction.WinnerID,
+			Seller:      resource.Owner,
+			Volume:      auction.Allocations[0].Volume,
+			Price:       auction.WinnerPrice,
+			Allocations: auction.Allocations,
+			Timestamp:   currentTime,
+		}
+
+		settlementKey := ac.createCompositeKey(ctx, settlementObjectType, resourceID, auctionID)
+		settlementJSON, err := json.Marshal(settlement)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal settlement: %v", err)
+		}
+		updates[settlementKey] = settlementJSON
+	}
+
+	var unsoldJSON []byte
+	unsoldEvent := "AuctionUnsold"
+	if !reserveMet || !minBiddersMet {
+		reason := reasonReserveNotMet
+		if !minBiddersMet {
+			reason = reasonMinBiddersNotMet
+			unsoldEvent = "AuctionVoid"
+		}
+
+		unsold := UnsoldRecord{
+			AuctionID:  auctionID,
+			ResourceID: resourceID,
+			Reason:     reason,
+			HighestBid: highestBid,
+			Reserve:    auction.ReservePrice,
+			Timestamp:  currentTime,
+		}
+
+		unsoldJSON, err = json.Marshal(unsold)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal unsold record: %v", err)
+		}
+		updates[ac.createCompositeKey(ctx, unsoldObjectType, resourceID, auctionID)] = unsoldJSON
+	}
+
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return nil, err
+	}
+
+	if unsoldJSON != nil {
+		if err := ctx.GetStub().SetEvent(unsoldEvent, unsoldJSON); err != nil {
+			return nil, fmt.Errorf("failed to set event: %v", err)
+		}
+	}
+
+	return auction, nil
+}
+
+// CombinatorialBid places one all-or-nothing bid on the active auctions of several resources.
+// The auctions must share a deadline so the bundle can be settled in a single transaction.
+func (ac *EnergyAuctionContract) CombinatorialBid(ctx contractapi.TransactionContextInterface, resourceIDs []string, totalAmount float64) error {
+	if len(resourceIDs) < 2 {
+		return fmt.Errorf("a combinatorial bid must span at least two resources: %w", ErrInvalidArgument)
+	}
+
+	if totalAmount <= 0 {
+		return fmt.Errorf("bid amount must be greater than zero: %w", ErrInvalidArgument)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	bundle := BundleBid{
+		BundleID:    ctx.GetStub().GetTxID(),
+		Bidder:      clientID,
+		ResourceIDs: []string{},
+		AuctionIDs:  []string{},
+		TotalPrice:  ac.toMinorUnits(totalAmount),
+		Timestamp:   currentTime,
+		Status:      bundlePending,
+	}
+
+	seen := make(map[string]bool)
+	for _, resourceID := range resourceIDs {
+		if seen[resourceID] {
+			return fmt.Errorf("resource with ID %s is listed more than once: %w", resourceID, ErrInvalidArgument)
+		}
+		seen[resourceID] = true
+
+		resource, err := ac.fetchResource(ctx, resourceID)
+		if err != nil {
+			return err
+		}
+
+		if clientID == resource.Owner {
+			return fmt.Errorf("resource owner cannot bid on their own auction: %w", ErrUnauthorized)
+		}
+
+		auction, err := ac.fetchActiveAuction(ctx, resourceID)
+		if err != nil {
+			return err
+		}
+
+		if auction.Deadline < currentTime {
+			return fmt.Errorf("auction %s for resource with ID %s has expired: %w", auction.AuctionID, resourceID, ErrAuctionInactive)
+		}
+
+		if len(bundle.AuctionIDs) > 0 && auction.Deadline != bundle.Deadline {
+			return fmt.Errorf("auctions in a combinatorial bid must share the same deadline: %w", ErrInvalidArgument)
+		}
+
+		bundle.Deadline = auction.Deadline
+		bundle.ResourceIDs = append(bundle.ResourceIDs, resourceID)
+		bundle.AuctionIDs = append(bundle.AuctionIDs, auction.AuctionID)
+	}
+
+	bundleKey := ac.createCompositeKey(ctx, bundleObjectType, bundle.BundleID)
+	return ac.storeObject(ctx, bundleKey, bundle)
+}
+
+// EndCombinatorialAuctions settles every pending bundle whose deadline has passed. Bundles are
+// considered from the highest total down. A bundle wins only if it beats the sum of the best
+// individual bids and covers each auction's floor, in which case it takes every auction it spans
+// and pays that auction's floor price. Otherwise it loses and the auctions are left for EndAuction,
+// so bundles should be settled before the individual auctions are ended.
+func (ac *EnergyAuctionContract) EndCombinatorialAuctions(ctx contractapi.TransactionContextInterface) ([]BundleBid, error) {
+	currentTime, err := ac.currentTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(bundleObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve combinatorial bids: %v", err)
+	}
+	defer results.Close()
+
+	bundles := []BundleBid{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var bundle BundleBid
+		if err := json.Unmarshal(next.Value, &bundle); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal combinatorial bid: %v", err)
+		}
+
+		if bundle.Status == bundlePending && bundle.Deadline < currentTime {
+			bundles = append(bundles, bundle)
+		}
+	}
+
+	sort.Slice(bundles, func(i, j int) bool {
+		if bundles[i].TotalPrice != bundles[j].TotalPrice {
+			return bundles[i].TotalPrice > bundles[j].TotalPrice
+		}
+		if bundles[i].Timestamp != bundles[j].Timestamp {
+			return bundles[i].Timestamp < bundles[j].Timestamp
+		}
+		return bundles[i].BundleID < bundles[j].BundleID
+	})
+
+	updates := make(map[string][]byte)
+	claimed := make(map[string]bool)
+
+	for i := range bundles {
+		bundle := &bundles[i]
+		bundle.Status = bundleLost
+
+		auctions := make([]*EnergyAuction, len(bundle.ResourceIDs))
+		resources := make([]*EnergyResource, len(bundle.ResourceIDs))
+		prices := make([]int64, len(bundle.ResourceIDs))
+		competing, floor := int64(0), int64(0)
+		available := true
+
+		for k, resourceID := range bundle.ResourceIDs {
+			auction, err := ac.fetchAuction(ctx, resourceID, bundle.AuctionIDs[k])
+			if err != nil {
+				return nil, err
+			}
+
+			resource, err := ac.fetchResource(ctx, resourceID)
+			if err != nil {
+				return nil, err
+			}
+
+			if !auction.IsActive || claimed[resourceID] {
+				available = false
+				break
+			}
+
+			highestBid := int64(0)
+			for _, bid := range auction.Bids {
+				highestBid = max(highestBid, bid.BidPrice)
+			}
+
+			auctions[k], resources[k] = auction, resource
+			prices[k] = max(highestBid, auction.ReservePrice, resource.Price)
+			competing += highestBid
+			floor += prices[k]
+		}
+
+		if !available || bundle.TotalPrice <= competing || bundle.TotalPrice < floor {
+			if err := ac.addUpdate(updates, ac.createCompositeKey(ctx, bundleObjectType, bundle.BundleID), bundle); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		bundle.Status = bundleWon
+		for k, resourceID := range bundle.ResourceIDs {
+			claimed[resourceID] = true
+
+			auction, resource := auctions[k], resources[k]
+			auction.IsActive = false
+			auction.FinalizedTxID = ctx.GetStub().GetTxID()
+			auction.WinnerID = bundle.Bidder
+			auction.WinnerPrice = prices[k]
+			resource.AuctionStatus = false
+			resource.IsAvailable = false
+
+			if err := ac.releaseLock(ctx, resourceID); err != nil {
+				return nil, err
+			}
+
+			settlement := Settlement{
+				AuctionID:  auction.AuctionID,
+				ResourceID: resourceID,
+				Buyer:      bundle.Bidder,
+				Seller:     resource.Owner,
+				Volume:     resource.Volume,
+				Price:      prices[k],
+				Timestamp:  currentTime,
+			}
+
+			if err := ac.addUpdate(updates, ac.createCompositeKey(ctx, auctionObjectType, resourceID, auction.AuctionID), auction); err != nil {
+				return nil, err
+			}
+			if err := ac.addUpdate(updates, ac.createCompositeKey(ctx, resourceObjectType, resourceID), resource); err != nil {
+				return nil, err
+			}
+			if err := ac.addUpdate(updates, ac.createCompositeKey(ctx, settlementObjectType, resourceID, auction.AuctionID), settlement); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := ac.addUpdate(updates, ac.createCompositeKey(ctx, bundleObjectType, bundle.BundleID), bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ac.batchStore(ctx, updates); err != nil {
+		return nil, err
+	}
+
+	return bundles, nil
+}
+
+func (ac *EnergyAuctionContract) GetSettlement(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (*Settlement, error) {
+	settlementKey := ac.createCompositeKey(ctx, settlementObjectType, resourceID, auctionID)
+
+	fetchedSettlement, err := ctx.GetStub().GetState(settlementKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve settlement: %v", err)
+	}
+	if fetchedSettlement == nil {
+		return nil, fmt.Errorf("settlement for auction %s of resource with ID %s does not exist: %w", auctionID, resourceID, ErrSettlementNotFound)
+	}
+
+	var settlement Settlement
+	if err := json.Unmarshal(fetchedSettlement, &settlement); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal settlement: %v", err)
+	}
+	return &settlement, nil
+}
+
+func (ac *EnergyAuctionContract) GetUnsoldRecord(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (*UnsoldRecord, error) {
+	unsoldKey := ac.createCompositeKey(ctx, unsoldObjectType, resourceID, auctionID)
+
+	fetchedUnsold, err := ctx.GetStub().GetState(unsoldKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve unsold record: %v", err)
+	}
+	if fetchedUnsold == nil {
+		return nil, fmt.Errorf("unsold record for auction %s of resource with ID %s does not exist: %w", auctionID, resourceID, ErrUnsoldRecordNotFound)
+	}
+
+	var unsold UnsoldRecord
+	if err := json.Unmarshal(fetchedUnsold, &unsold); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal unsold record: %v", err)
+	}
+	return &unsold, nil
+}
+
+func (ac *EnergyAuctionContract) GetSettlementsInRange(ctx contractapi.TransactionContextInterface, startUnix, endUnix int64) ([]Settlement, error) {
+	if startUnix > endUnix {
+		return nil, fmt.Errorf("start of range must not be after its end: %w", ErrInvalidArgument)
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(settlementObjectType, []string{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+		return nil, fmt.Errorf("failed to retrieve settlements: %v", err)
 	}
 	defer results.Close()
 
-	var resources []EnergyResource
+	settlements := []Settlement{}
 	for results.HasNext() {
 		next, err := results.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		var resource EnergyResource
-		err = json.Unmarshal(next.Value, &resource)
-		if err != nil {
-			return nil, err
+		var settlement Settlement
+		if err := json.Unmarshal(next.Value, &settlement); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal settlement: %v", err)
 		}
-		resources = append(resources, resource)
+
+		if settlement.Timestamp < startUnix || settlement.Timestamp > endUnix {
+			continue
+		}
+		settlements = append(settlements, settlement)
 	}
 
-	sort.Slice(resources, func(i, j int) bool {
-		return resources[i].Price < resources[j].Price
+	sort.SliceStable(settlements, func(i, j int) bool {
+		return settlements[i].Timestamp < settlements[j].Timestamp
 	})
 
-	return resources, nil
+	return settlements, nil
 }
 
-func (ac *EnergyAuctionContract) GetMeritOrderPaginated(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) ([]EnergyResource, string, error) {
-	results, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(resourceObjectType, []string{}, pageSize, bookmark)
+func (ac *EnergyAuctionContract) GetWonVolumeByBidder(ctx contractapi.TransactionContextInterface, bidderID string) (float64, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to retrieve resources: %v", err)
+		return 0, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	if clientID != bidderID {
+		if err := ctx.GetClientIdentity().AssertAttributeValue(auditorAttribute, "true"); err != nil {
+			return 0, fmt.Errorf("client is not authorized to query another bidder's won volume: %w", ErrUnauthorized)
+		}
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(settlementObjectType, []string{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve settlements: %v", err)
 	}
 	defer results.Close()
 
-	var resources []EnergyResource
+	wonVolume := 0.0
 	for results.HasNext() {
 		next, err := results.Next()
 		if err != nil {
-			return nil, "", err
+			return 0, err
 		}
 
-		_, splitKey, err := ctx.GetStub().SplitCompositeKey(next.Key)
-		if err != nil {
-			return nil, "", err
+		var settlement Settlement
+		if err := json.Unmarshal(next.Value, &settlement); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal settlement: %v", err)
 		}
-		resourceID := splitKey[len(splitKey)-1]
 
-		resource, err := ac.fetchResource(ctx, resourceID)
-		if err != nil {
-			return nil, "", err
+		// Settlements from combinatorial auctions have a single buyer and no allocations.
+		if len(settlement.Allocations) == 0 {
+			if settlement.Buyer == bidderID {
+				wonVolume += settlement.Volume
+			}
+			continue
 		}
 
-		resources = append(resources, *resource)
+		for _, allocation := range settlement.Allocations {
+			if allocation.Bidder == bidderID {
+				wonVolume += allocation.Volume
+			}
+		}
 	}
 
-	return resources, metadata.Bookmark, nil
+	return wonVolume, nil
 }
 
-func (ac *EnergyAuctionContract) StartAuction(ctx contractapi.TransactionContextInterface, resourceID string, duration int64) error {
-	resource, err := ac.fetchResource(ctx, resourceID)
+// GetTopBidders ranks winners by the total volume they have won across all settlements,
+// returning at most n. Ties are ordered by bidder ID.
+func (ac *EnergyAuctionContract) GetTopBidders(ctx contractapi.TransactionContextInterface, n int) ([]BidderStanding, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of bidders must be greater than zero: %w", ErrInvalidArgument)
+	}
+
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(settlementObjectType, []string{})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to retrieve settlements: %v", err)
 	}
+	defer results.Close()
 
-	if resource.AuctionStatus {
-		return fmt.Errorf("auction for resource with ID %s is already active", resourceID)
+	standings := map[string]*BidderStanding{}
+	addWin := func(bidder string, volume float64) {
+		standing, ok := standings[bidder]
+		if !ok {
+			standing = &BidderStanding{Bidder: bidder}
+			standings[bidder] = standing
+		}
+		standing.TotalVolume += volume
+		standing.Wins++
 	}
 
-	if !resource.IsAvailable {
-		return fmt.Errorf("resource with ID %s is not available", resourceID)
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var settlement Settlement
+		if err := json.Unmarshal(next.Value, &settlement); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal settlement: %v", err)
+		}
+
+		// Settlements from combinatorial auctions have a single buyer and no allocations.
+		if len(settlement.Allocations) == 0 {
+			addWin(settlement.Buyer, settlement.Volume)
+			continue
+		}
+		for _, allocation := range settlement.Allocations {
+			addWin(allocation.Bidder, allocation.Volume)
+		}
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
-	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+	leaderboard := make([]BidderStanding, 0, len(standings))
+	for _, standing := range standings {
+		leaderboard = append(leaderboard, *standing)
 	}
 
-	auction := EnergyAuction{
-		ResourceID: resourceID,
-		Deadline:   currentTimestamp.Seconds + duration,
-		Bids:       []Bid{},
-		IsActive:   true,
+	sort.Slice(leaderboard, func(i, j int) bool {
+		if leaderboard[i].TotalVolume != leaderboard[j].TotalVolume {
+			return leaderboard[i].TotalVolume > leaderboard[j].TotalVolume
+		}
+		return leaderboard[i].Bidder < leaderboard[j].Bidder
+	})
+
+	if n > len(leaderboard) {
+		n = len(leaderboard)
 	}
-	resource.AuctionStatus = true
+	return leaderboard[:n], nil
+}
 
-	updates := make(map[string][]byte)
+// Helper functions
 
-	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
-	resourceJSON, err := json.Marshal(resource)
+// currentTime guards against peers that leave the transaction timestamp unset.
+func (ac *EnergyAuctionContract) currentTime(ctx contractapi.TransactionContextInterface) (int64, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
-		return fmt.Errorf("failed to marshal resource: %v", err)
+		return 0, fmt.Errorf("failed to get current block timestamp: %v", err)
 	}
-	updates[resourceKey] = resourceJSON
+	if timestamp == nil {
+		return 0, fmt.Errorf("transaction timestamp is not set")
+	}
+	return timestamp.Seconds, nil
+}
 
-	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID)
-	auctionJSON, err := json.Marshal(auction)
-	if err != nil {
-		return fmt.Errorf("failed to marshal auction: %v", err)
+// clearUniformPrice fills bids from the highest per-unit price down until the volume runs out.
+// Every winner pays the per-unit price of the highest bid left out entirely, or floor when
+// all bids at or above floor fit within the volume.
+func (ac *EnergyAuctionContract) clearUniformPrice(sortedBids []Bid, volume float64, floor int64) ([]Allocation, float64, int64) {
+	allocations := []Allocation{}
+	remaining := volume
+	clearingPrice := floor
+
+	for _, bid := range sortedBids {
+		if bid.BidPrice < floor {
+			break
+		}
+
+		if remaining <= 0 {
+			clearingPrice = bid.BidPrice
+			break
+		}
+
+		// Bids placed before partial volumes were supported ask for the whole resource.
+		requested := bid.RequestedVolume
+		if requested <= 0 {
+			requested = volume
+		}
+
+		allocated := math.Min(requested, remaining)
+		allocations = append(allocations, Allocation{Bidder: bid.Bidder, Volume: allocated})
+		remaining -= allocated
 	}
-	updates[auctionKey] = auctionJSON
 
-	return ac.batchStore(ctx, updates)
+	return allocations, remaining, clearingPrice
 }
 
-func (ac *EnergyAuctionContract) GetAuction(ctx contractapi.TransactionContextInterface, resourceID string) (string, error) {
-	auction, err := ac.fetchAuction(ctx, resourceID)
-	if err != nil {
-		return "", err
+// roundPrice rounds a price in minor units to the given number of decimal places, half away
+// from zero. When that would exceed ceiling the price is rounded down instead, and when no
+// rounded price lies between floor and ceiling the price is left unrounded.
+func (ac *EnergyAuctionContract) roundPrice(units int64, decimals int, floor, ceiling int64) int64 {
+	step := int64(1)
+	for i := decimals; i < maxPriceDecimals; i++ {
+		step *= 10
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
-	if err != nil {
-		return "", fmt.Errorf("failed to get current block timestamp: %v", err)
+	rounded := (units + step/2) / step * step
+	if rounded > ceiling {
+		rounded = units / step * step
 	}
-
-	if auction.Deadline > currentTimestamp.Seconds {
-		auction.Bids = []Bid{}
+	if rounded < floor {
+		return units
 	}
+	return rounded
+}
 
-	return ac.marshalToString(auction)
+func (ac *EnergyAuctionContract) toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * minorUnitsPerUnit))
+}
+
+func (ac *EnergyAuctionContract) fromMinorUnits(units int64) float64 {
+	return float64(units) / minorUnitsPerUnit
 }
 
-func (ac *EnergyAuctionContract) Bid(ctx contractapi.TransactionContextInterface, resourceID string, bidAmount float64) error {
-	auction, err := ac.fetchAuction(ctx, resourceID)
+// lockResource takes the lock for auctionType unless this chaincode or one of its lock peers
+// already holds a lock on the resource. Taking a lock this chaincode already holds for the
+// same type succeeds.
+func (ac *EnergyAuctionContract) lockResource(ctx contractapi.TransactionContextInterface, resourceID, auctionType string) error {
+	lock, err := ac.fetchLock(ctx, resourceID)
 	if err != nil {
 		return err
 	}
+	if lock != nil {
+		if lock.AuctionType != auctionType {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction: %w", resourceID, lock.AuctionType, ErrResourceLocked)
+		}
+		return nil
+	}
 
-	resource, err := ac.fetchResource(ctx, resourceID)
-	if err != nil {
+	if err := ac.checkPeerLocks(ctx, resourceID); err != nil {
 		return err
 	}
 
-	if bidAmount <= resource.Price {
-		return fmt.Errorf("bid amount must be higher than resource price")
-	}
+	lockKey := ac.createCompositeKey(ctx, lockObjectType, resourceID)
+	return ac.storeObject(ctx, lockKey, ResourceLock{ResourceID: resourceID, AuctionType: auctionType})
+}
 
-	if !auction.IsActive {
-		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
+func (ac *EnergyAuctionContract) releaseLock(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	if err := ctx.GetStub().DelState(ac.createCompositeKey(ctx, lockObjectType, resourceID)); err != nil {
+		return fmt.Errorf("failed to release resource lock: %v", err)
 	}
+	return nil
+}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
+// checkPeerLocks fails if any lock peer holds a lock on the resource. Reads made through
+// InvokeChaincode on the same channel join this transaction's read set, so a peer lock taken
+// concurrently invalidates one of the two transactions.
+func (ac *EnergyAuctionContract) checkPeerLocks(ctx contractapi.TransactionContextInterface, resourceID string) error {
+	fetchedPeers, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, configObjectType, "lockPeers"))
 	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+		return fmt.Errorf("failed to retrieve lock peers: %v", err)
 	}
-
-	if auction.Deadline < currentTimestamp.Seconds {
-		return ac.EndAuction(ctx, resourceID)
+	if fetchedPeers == nil {
+		return nil
 	}
 
-	clientID, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return fmt.Errorf("failed to get client ID: %v", err)
+	var chaincodeNames []string
+	if err := json.Unmarshal(fetchedPeers, &chaincodeNames); err != nil {
+		return fmt.Errorf("failed to unmarshal lock peers: %v", err)
 	}
 
-	bid := Bid{
-		BidID:      fmt.Sprintf("%s:%s:%d", resourceID, clientID, currentTimestamp.Seconds),
-		ResourceID: resourceID,
-		Bidder:     clientID,
-		BidPrice:   bidAmount,
-		Timestamp:  currentTimestamp.Seconds,
+	for _, chaincodeName := range chaincodeNames {
+		response := ctx.GetStub().InvokeChaincode(chaincodeName, [][]byte{[]byte("GetResourceLock"), []byte(resourceID)}, "")
+		if response.Status != shim.OK {
+			return fmt.Errorf("failed to query resource lock in chaincode %s: %s", chaincodeName, response.Message)
+		}
+		if auctionType := string(response.Payload); auctionType != "" {
+			return fmt.Errorf("resource with ID %s is locked by a %s auction in chaincode %s: %w", resourceID, auctionType, chaincodeName, ErrResourceLocked)
+		}
 	}
-
-	auction.Bids = append(auction.Bids, bid)
-
-	return ac.storeAuction(ctx, resourceID, *auction)
+	return nil
 }
 
-func (ac *EnergyAuctionContract) EndAuction(ctx contractapi.TransactionContextInterface, resourceID string) error {
-	auction, err := ac.fetchAuction(ctx, resourceID)
+// checkOwnerOrAdmin lets only the resource owner and admins perform action, which completes
+// the error message. A resource that is no longer stored can only be handled by an admin.
+func (ac *EnergyAuctionContract) checkOwnerOrAdmin(ctx contractapi.TransactionContextInterface, resourceID string, resource *EnergyResource, action string) error {
+	clientID, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return err
-	}
-
-	if !auction.IsActive {
-		return fmt.Errorf("auction for resource with ID %s is not active", resourceID)
+		return fmt.Errorf("failed to get client ID: %v", err)
 	}
 
-	currentTimestamp, err := ctx.GetStub().GetTxTimestamp()
-	if err != nil {
-		return fmt.Errorf("failed to get current block timestamp: %v", err)
+	if resource != nil && resource.Owner != "" && resource.Owner == clientID {
+		return nil
 	}
 
-	if auction.Deadline > currentTimestamp.Seconds {
-		return fmt.Errorf("auction for resource with ID %s has not yet expired", resourceID)
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return fmt.Errorf("only the owner of resource with ID %s or an admin can %s: %w", resourceID, action, ErrUnauthorized)
 	}
+	return nil
+}
 
-	sort.Slice(auction.Bids, func(i, j int) bool {
-		return auction.Bids[i].BidPrice > auction.Bids[j].BidPrice
-	})
-
-	auction.IsActive = false
-
-	resource, err := ac.fetchResource(ctx, auction.ResourceID)
+func (ac *EnergyAuctionContract) fetchLock(ctx contractapi.TransactionContextInterface, resourceID string) (*ResourceLock, error) {
+	fetchedLock, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, lockObjectType, resourceID))
 	if err != nil {
-		return err
-	}
-
-	resource.AuctionStatus = false
-
-	if len(auction.Bids) > 0 {
-		resource.IsAvailable = false
-		auction.WinnerID = auction.Bids[0].Bidder
-		if len(auction.Bids) > 1 {
-			auction.WinnerPrice = auction.Bids[1].BidPrice
-		} else {
-			auction.WinnerPrice = auction.Bids[0].BidPrice
-		}
+		return nil, fmt.Errorf("failed to retrieve resource lock: %v", err)
 	}
-
-	updates := make(map[string][]byte)
-
-	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID)
-	auctionJSON, err := json.Marshal(auction)
-	if err != nil {
-		return fmt.Errorf("failed to marshal auction: %v", err)
+	if fetchedLock == nil {
+		return nil, nil
 	}
-	updates[auctionKey] = auctionJSON
 
-	resourceKey := ac.createCompositeKey(ctx, resourceObjectType, resourceID)
-	resourceJSON, err := json.Marshal(resource)
-	if err != nil {
-		return fmt.Errorf("failed to marshal resource: %v", err)
+	var lock ResourceLock
+	if err := json.Unmarshal(fetchedLock, &lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource lock: %v", err)
 	}
-	updates[resourceKey] = resourceJSON
-
-	return ac.batchStore(ctx, updates)
+	return &lock, nil
 }
 
-// Helper functions
 func (ac *EnergyAuctionContract) marshalToString(v interface{}) (string, error) {
 	jsonData, err := json.Marshal(v)
 	if err != nil {
@@ -315,7 +2522,7 @@ func (ac *EnergyAuctionContract) checkResourceExists(ctx contractapi.Transaction
 		return fmt.Errorf("failed to interact with world state: %v", err)
 	}
 	if fetchedResource != nil {
-		return fmt.Errorf("a resource already exists with ID: %s", resourceID)
+		return fmt.Errorf("a resource already exists with ID: %s: %w", resourceID, ErrAlreadyExists)
 	}
 	return nil
 }
@@ -328,7 +2535,7 @@ func (ac *EnergyAuctionContract) fetchResource(ctx contractapi.TransactionContex
 		return nil, fmt.Errorf("failed to retrieve resource: %v", err)
 	}
 	if fetchedResource == nil {
-		return nil, fmt.Errorf("resource with ID %s does not exist", resourceID)
+		return nil, fmt.Errorf("resource with ID %s does not exist: %w", resourceID, ErrResourceNotFound)
 	}
 
 	var resource EnergyResource
@@ -338,24 +2545,233 @@ func (ac *EnergyAuctionContract) fetchResource(ctx contractapi.TransactionContex
 	return &resource, nil
 }
 
-func (ac *EnergyAuctionContract) fetchAuction(ctx contractapi.TransactionContextInterface, resourceID string) (*EnergyAuction, error) {
-	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID)
+func (ac *EnergyAuctionContract) fetchAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID string) (*EnergyAuction, error) {
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID, auctionID)
 
 	fetchedAuction, err := ctx.GetStub().GetState(auctionKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve auction: %v", err)
 	}
 	if fetchedAuction == nil {
-		return nil, fmt.Errorf("auction for resource with ID %s does not exist", resourceID)
+		return nil, fmt.Errorf("auction %s for resource with ID %s does not exist: %w", auctionID, resourceID, ErrAuctionNotFound)
 	}
 
+	return ac.decodeAuction(ctx, auctionKey, fetchedAuction)
+}
+
+// decodeAuction unmarshals an auction and fills in what version 0 records lack: the IDs are
+// recovered from the key and a missing bid list becomes empty. Fields that did not exist yet,
+// such as the reserve or bid cap, keep their zero value, which means none is enforced.
+func (ac *EnergyAuctionContract) decodeAuction(ctx contractapi.TransactionContextInterface, key string, data []byte) (*EnergyAuction, error) {
 	var auction EnergyAuction
-	if err := json.Unmarshal(fetchedAuction, &auction); err != nil {
+	if err := json.Unmarshal(data, &auction); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal auction: %v", err)
 	}
+
+	if auction.SchemaVersion < 1 {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split auction key: %v", err)
+		}
+		if auction.ResourceID == "" && len(attributes) > 0 {
+			auction.ResourceID = attributes[0]
+		}
+		if auction.AuctionID == "" && len(attributes) > 1 {
+			auction.AuctionID = attributes[1]
+		}
+		if auction.Bids == nil {
+			auction.Bids = []Bid{}
+		}
+	}
+
+	if auction.SchemaVersion < 2 {
+		auction.PriceDecimals = maxPriceDecimals
+	}
+	auction.SchemaVersion = auctionSchemaVersion
+
 	return &auction, nil
 }
 
+func (ac *EnergyAuctionContract) fetchActiveAuction(ctx contractapi.TransactionContextInterface, resourceID string) (*EnergyAuction, error) {
+	auctions, err := ac.fetchAuctionsForResource(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range auctions {
+		if auctions[i].IsActive {
+			return &auctions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("resource with ID %s has no active auction: %w", resourceID, ErrAuctionInactive)
+}
+
+func (ac *EnergyAuctionContract) addToExport(ctx contractapi.TransactionContextInterface, export *StateExport, objectType, key string, value []byte) error {
+	switch objectType {
+	case resourceObjectType:
+		var resource EnergyResource
+		if err := json.Unmarshal(value, &resource); err != nil {
+			return fmt.Errorf("failed to unmarshal resource: %v", err)
+		}
+		_, splitKey, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return err
+		}
+		export.Resources = append(export.Resources, ResourceWithID{ResourceID: splitKey[len(splitKey)-1], Resource: resource})
+	case auctionObjectType:
+		auction, err := ac.decodeAuction(ctx, key, value)
+		if err != nil {
+			return err
+		}
+		export.Auctions = append(export.Auctions, *auction)
+	case settlementObjectType:
+		var settlement Settlement
+		if err := json.Unmarshal(value, &settlement); err != nil {
+			return fmt.Errorf("failed to unmarshal settlement: %v", err)
+		}
+		export.Settlements = append(export.Settlements, settlement)
+	case unsoldObjectType:
+		var unsold UnsoldRecord
+		if err := json.Unmarshal(value, &unsold); err != nil {
+			return fmt.Errorf("failed to unmarshal unsold record: %v", err)
+		}
+		export.UnsoldRecords = append(export.UnsoldRecords, unsold)
+	case bundleObjectType:
+		var bundle BundleBid
+		if err := json.Unmarshal(value, &bundle); err != nil {
+			return fmt.Errorf("failed to unmarshal bundle bid: %v", err)
+		}
+		export.Bundles = append(export.Bundles, bundle)
+	case lockObjectType:
+		var lock ResourceLock
+		if err := json.Unmarshal(value, &lock); err != nil {
+			return fmt.Errorf("failed to unmarshal resource lock: %v", err)
+		}
+		export.Locks = append(export.Locks, lock)
+	case configObjectType:
+		_, splitKey, err := ctx.GetStub().SplitCompositeKey(key)
+		if err != nil {
+			return err
+		}
+		var target *[]string
+		switch splitKey[len(splitKey)-1] {
+		case "allowedTypes":
+			target = &export.AllowedTypes
+		case "lockPeers":
+			target = &export.LockPeers
+		default:
+			return nil
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			return fmt.Errorf("failed to unmarshal %s config: %v", splitKey[len(splitKey)-1], err)
+		}
+	}
+	return nil
+}
+
+func (ac *EnergyAuctionContract) fetchAllowedTypes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	allowedTypesJSON, err := ctx.GetStub().GetState(ac.createCompositeKey(ctx, configObjectType, "allowedTypes"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve allowed resource types: %v", err)
+	}
+	if allowedTypesJSON == nil {
+		return defaultAllowedTypes, nil
+	}
+
+	var allowedTypes []string
+	if err := json.Unmarshal(allowedTypesJSON, &allowedTypes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal allowed resource types: %v", err)
+	}
+	return allowedTypes, nil
+}
+
+func (ac *EnergyAuctionContract) fetchResourcesWithIDs(ctx contractapi.TransactionContextInterface, availableOnly bool) ([]ResourceWithID, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(resourceObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve resources: %v", err)
+	}
+	defer results.Close()
+
+	resources := []ResourceWithID{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var resource EnergyResource
+		if err := json.Unmarshal(next.Value, &resource); err != nil {
+			return nil, err
+		}
+
+		if availableOnly && (!resource.IsAvailable || resource.AuctionStatus) {
+			continue
+		}
+
+		_, splitKey, err := ctx.GetStub().SplitCompositeKey(next.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, ResourceWithID{
+			ResourceID: splitKey[len(splitKey)-1],
+			Resource:   resource,
+		})
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Resource.Price < resources[j].Resource.Price
+	})
+
+	return resources, nil
+}
+
+func (ac *EnergyAuctionContract) fetchSettlementsForResource(ctx contractapi.TransactionContextInterface, resourceID string) ([]Settlement, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(settlementObjectType, []string{resourceID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve settlements: %v", err)
+	}
+	defer results.Close()
+
+	settlements := []Settlement{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var settlement Settlement
+		if err := json.Unmarshal(next.Value, &settlement); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal settlement: %v", err)
+		}
+		settlements = append(settlements, settlement)
+	}
+	return settlements, nil
+}
+
+func (ac *EnergyAuctionContract) fetchAuctionsForResource(ctx contractapi.TransactionContextInterface, resourceID string) ([]EnergyAuction, error) {
+	results, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{resourceID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve auctions: %v", err)
+	}
+	defer results.Close()
+
+	auctions := []EnergyAuction{}
+	for results.HasNext() {
+		next, err := results.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		auction, err := ac.decodeAuction(ctx, next.Key, next.Value)
+		if err != nil {
+			return nil, err
+		}
+		auctions = append(auctions, *auction)
+	}
+	return auctions, nil
+}
+
 func (ac *EnergyAuctionContract) storeObject(ctx contractapi.TransactionContextInterface, key string, object interface{}) error {
 	objectJSON, err := json.Marshal(object)
 	if err != nil {
@@ -364,8 +2780,8 @@ func (ac *EnergyAuctionContract) storeObject(ctx contractapi.TransactionContextI
 	return ctx.GetStub().PutState(key, objectJSON)
 }
 
-func (ac *EnergyAuctionContract) storeAuction(ctx contractapi.TransactionContextInterface, resourceID string, auction EnergyAuction) error {
-	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID)
+func (ac *EnergyAuctionContract) storeAuction(ctx contractapi.TransactionContextInterface, resourceID, auctionID string, auction EnergyAuction) error {
+	auctionKey := ac.createCompositeKey(ctx, auctionObjectType, resourceID, auctionID)
 	return ac.storeObject(ctx, auctionKey, auction)
 }
 
@@ -374,6 +2790,15 @@ func (ac *EnergyAuctionContract) storeResource(ctx contractapi.TransactionContex
 	return ac.storeObject(ctx, resourceKey, resource)
 }
 
+func (ac *EnergyAuctionContract) addUpdate(updates map[string][]byte, key string, object interface{}) error {
+	objectJSON, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object: %v", err)
+	}
+	updates[key] = objectJSON
+	return nil
+}
+
 func (ac *EnergyAuctionContract) batchStore(ctx contractapi.TransactionContextInterface, updates map[string][]byte) error {
 	for key, value := range updates {
 		if err := ctx.GetStub().PutState(key, value); err != nil {
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type testIdentity struct {
+	id    string
+	admin bool
+}
+
+func (ti testIdentity) GetID() (string, error) {
+	return ti.id, nil
+}
+
+func (ti testIdentity) GetMSPID() (string, error) {
+	return "Org1MSP", nil
+}
+
+func (ti testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	if attrName == adminAttribute && ti.admin {
+		return "true", true, nil
+	}
+	return "", false, nil
+}
+
+func (ti testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	if value, found, _ := ti.GetAttributeValue(attrName); !found || value != attrValue {
+		return fmt.Errorf("attribute %s is not %s", attrName, attrValue)
+	}
+	return nil
+}
+
+func (ti testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{}, nil
+}
+
+func newTestContext(stub *shimtest.MockStub, clientID string) *contractapi.TransactionContext {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(testIdentity{id: clientID})
+	return ctx
+}
+
+// startTransaction begins a new mock transaction at the given Unix time.
+func startTransaction(stub *shimtest.MockStub, txID string, seconds int64) {
+	stub.MockTransactionStart(txID)
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: seconds}
+}
+
+// settleAuction runs an auction on a fresh resource of volume 10 priced at 5 and owned by
+// producer, in which each bidder asks for the given volume at the given price.
+func settleAuction(t *testing.T, ac *EnergyAuctionContract, stub *shimtest.MockStub, reservePrice float64, priceDecimals int, bids map[string][2]float64) {
+	t.Helper()
+	seller := newTestContext(stub, "producer")
+
+	startTransaction(stub, "tx1", 1000)
+	if err := ac.SubmitEnergyResource(seller, "res1", 10, 5, "solar", "north", ""); err != nil {
+		t.Fatalf("SubmitEnergyResource failed: %v", err)
+	}
+	if err := ac.StartAuction(seller, "res1", "a1", 3600, reservePrice, 0, 0, priceDecimals); err != nil {
+		t.Fatalf("StartAuction failed: %v", err)
+	}
+	stub.MockTransactionEnd("tx1")
+
+	startTransaction(stub, "tx2", 2000)
+	for bidder, bid := range bids {
+		if err := ac.Bid(newTestContext(stub, bidder), "res1", "a1", bid[0], bid[1]); err != nil {
+			t.Fatalf("Bid(%s) failed: %v", bidder, err)
+		}
+	}
+	stub.MockTransactionEnd("tx2")
+
+	startTransaction(stub, "tx3", 5000)
+	if err := ac.EndAuction(seller, "res1", "a1"); err != nil {
+		t.Fatalf("EndAuction failed: %v", err)
+	}
+	stub.MockTransactionEnd("tx3")
+}
+
+func TestStartAuctionFromLastSettlementRejectsResourceSoldInFull(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction_optimized", nil)
+	settleAuction(t, ac, stub, 0, 2, map[string][2]float64{"consumer1": {8, 10}, "consumer2": {6, 10}})
+
+	startTransaction(stub, "tx4", 6000)
+	err := ac.StartAuctionFromLastSettlement(newTestContext(stub, "producer"), "res1", "a2", 3600)
+	if !errors.Is(err, ErrResourceUnavailable) {
+		t.Fatalf("expected restarting a resource sold in full to fail with ErrResourceUnavailable, got %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+}
+
+func TestStartAuctionFromLastSettlementRelistsUnsoldVolume(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction_optimized", nil)
+	settleAuction(t, ac, stub, 5.5, 2, map[string][2]float64{"consumer1": {8, 4}})
+
+	seller := newTestContext(stub, "producer")
+	settlement, err := ac.GetSettlement(seller, "res1", "a1")
+	if err != nil {
+		t.Fatalf("GetSettlement failed: %v", err)
+	}
+
+	startTransaction(stub, "tx4", 6000)
+	err = ac.StartAuctionFromLastSettlement(newTestContext(stub, "consumer1"), "res1", "a2", 3600)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected a non-owner relisting to fail with ErrUnauthorized, got %v", err)
+	}
+	if err := ac.StartAuctionFromLastSettlement(seller, "res1", "a2", 3600); err != nil {
+		t.Fatalf("StartAuctionFromLastSettlement failed: %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+
+	auction, err := ac.fetchAuction(seller, "res1", "a2")
+	if err != nil {
+		t.Fatalf("fetchAuction failed: %v", err)
+	}
+	if auction.BidFloor != settlement.Price {
+		t.Errorf("expected a bid floor of %d from the last settlement, got %d", settlement.Price, auction.BidFloor)
+	}
+
+	resource, err := ac.fetchResource(seller, "res1")
+	if err != nil {
+		t.Fatalf("fetchResource failed: %v", err)
+	}
+	if !resource.IsAvailable || resource.Volume != 6 {
+		t.Errorf("expected the resource to be listed again with the 6 unsold units, got %+v", resource)
+	}
+
+	startTransaction(stub, "tx5", 7000)
+	err = ac.Bid(newTestContext(stub, "consumer2"), "res1", "a2", 9, 10)
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected a bid for more than the unsold volume to be rejected, got %v", err)
+	}
+	stub.MockTransactionEnd("tx5")
+}
+
+func TestRunnerUpIsBestBidWithoutAllocation(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction_optimized", nil)
+	settleAuction(t, ac, stub, 0, 2, map[string][2]float64{
+		"consumer1": {9, 5},
+		"consumer2": {8, 5},
+		"consumer3": {7, 5},
+		"consumer4": {6, 5},
+	})
+
+	auction, err := ac.fetchAuction(newTestContext(stub, "producer"), "res1", "a1")
+	if err != nil {
+		t.Fatalf("fetchAuction failed: %v", err)
+	}
+	if len(auction.Allocations) != 2 || auction.WinnerPrice != 700 {
+		t.Fatalf("expected two winners clearing at 700, got %+v at %d", auction.Allocations, auction.WinnerPrice)
+	}
+	if auction.RunnerUpID != "consumer3" {
+		t.Errorf("expected consumer3, the best bid without an allocation, as runner-up, got %q", auction.RunnerUpID)
+	}
+	if auction.RunnerUpPrice != 600 {
+		t.Errorf("expected the runner-up to clear at 600 without the winner, got %d", auction.RunnerUpPrice)
+	}
+}
+
+func TestRoundedPriceStaysAboveReserve(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction_optimized", nil)
+	settleAuction(t, ac, stub, 10.5, 0, map[string][2]float64{"consumer1": {10.8, 10}})
+
+	auction, err := ac.fetchAuction(newTestContext(stub, "producer"), "res1", "a1")
+	if err != nil {
+		t.Fatalf("fetchAuction failed: %v", err)
+	}
+	if auction.WinnerPrice != 1050 {
+		t.Errorf("expected the unrounded reserve of 1050 when no whole price fits between reserve and bid, got %d", auction.WinnerPrice)
+	}
+}
+
+func TestRelistResourceClearsUnsoldAuction(t *testing.T) {
+	ac := new(EnergyAuctionContract)
+	stub := shimtest.NewMockStub("second_price_auction_optimized", nil)
+	settleAuction(t, ac, stub, 20, 2, map[string][2]float64{"consumer1": {8, 10}})
+
+	startTransaction(stub, "tx4", 6000)
+	err := ac.RelistResource(newTestContext(stub, "consumer1"), "res1")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected a non-owner relisting to fail with ErrUnauthorized, got %v", err)
+	}
+
+	seller := newTestContext(stub, "producer")
+	if err := ac.RelistResource(seller, "res1"); err != nil {
+		t.Fatalf("RelistResource failed: %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+
+	if _, err := ac.fetchAuction(seller, "res1", "a1"); !errors.Is(err, ErrAuctionNotFound) {
+		t.Errorf("expected the unsold auction to be cleared, got %v", err)
+	}
+	if _, err := ac.GetUnsoldRecord(seller, "res1", "a1"); err != nil {
+		t.Errorf("expected the unsold record to stay, got %v", err)
+	}
+
+	startTransaction(stub, "tx5", 7000)
+	if err := ac.StartAuction(seller, "res1", "a1", 3600, 0, 0, 0, 2); err != nil {
+		t.Errorf("expected the auction ID to be reusable after relisting, got %v", err)
+	}
+	stub.MockTransactionEnd("tx5")
+}
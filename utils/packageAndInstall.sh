@@ -2,6 +2,12 @@
 
 CHAINCODE_NAME=${1:-"auction"}
 CHAINCODE_PATH=${2:-"../../../contracts/english_auction/"}
+COLLECTIONS_CONFIG=${3:-""}
+
+COLLECTIONS_FLAG=""
+if [ -n "$COLLECTIONS_CONFIG" ]; then
+    COLLECTIONS_FLAG="--collections-config $COLLECTIONS_CONFIG"
+fi
 
 set_org1() {
     export CORE_PEER_TLS_ENABLED=true
@@ -27,12 +33,14 @@ approve_package() {
     package_id=$(extract_package_id)
     export CC_PACKAGE_ID=$package_id
 
-    peer lifecycle chaincode approveformyorg -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --channelID mychannel --name $CHAINCODE_NAME --version 1.0 --package-id $CC_PACKAGE_ID --sequence 1 --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem"
+    peer lifecycle chaincode approveformyorg -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --channelID mychannel --name $CHAINCODE_NAME --version 1.0 --package-id $CC_PACKAGE_ID --sequence 1 $COLLECTIONS_FLAG --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem"
 }
 
 export PATH=${PWD}/../bin:$PATH
 export FABRIC_CFG_PATH=$PWD/../config/
 
+# The contracts pull in ../contractinfo through a replace directive, which only travels with the package once vendored
+(cd $CHAINCODE_PATH && go mod vendor)
 peer lifecycle chaincode package ${CHAINCODE_NAME}.tar.gz --path $CHAINCODE_PATH --lang golang --label $CHAINCODE_NAME
 
 set_org1
@@ -46,4 +54,4 @@ approve_package
 set_org1
 approve_package
 
-peer lifecycle chaincode commit -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --channelID mychannel --name $CHAINCODE_NAME --version 1.0 --sequence 1 --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt"
\ No newline at end of file
+peer lifecycle chaincode commit -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --channelID mychannel --name $CHAINCODE_NAME --version 1.0 --sequence 1 $COLLECTIONS_FLAG --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" --peerAddresses localhost:7051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt" --peerAddresses localhost:9051 --tlsRootCertFiles "${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt"
\ No newline at end of file